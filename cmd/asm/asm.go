@@ -6,8 +6,32 @@ import (
 	asm "github.com/andreax79/go-fcpu/pkg/assembler"
 	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// Symbols defined with -D NAME=value
+type defines map[string]fcpu.Word
+
+func (d defines) String() string {
+	return fmt.Sprint(map[string]fcpu.Word(d))
+}
+
+func (d defines) Set(s string) error {
+	name, value, hasValue := strings.Cut(s, "=")
+	if !hasValue {
+		d[name] = 1
+		return nil
+	}
+	v, err := strconv.ParseInt(value, 0, 32)
+	if err != nil {
+		return err
+	}
+	d[name] = fcpu.Word(v)
+	return nil
+}
+
 // Run obj file
 func run(objFilename string, verbose bool) {
 	cpu, err := fcpu.NewCPU(objFilename)
@@ -16,30 +40,46 @@ func run(objFilename string, verbose bool) {
 		fmt.Println(err)
 		return
 	}
-	cpu.Loop()
+	err = cpu.Loop()
 	if verbose {
 		cpu.PrintMemory()
 	}
+	if _, halt := err.(*fcpu.Halt); !halt {
+		fmt.Fprintln(os.Stderr, err) // unhandled trap
+		os.Exit(1)
+	}
 }
 
 func main() {
 	var verbose bool
+	var listingFilename string
+	var object bool
+	var symbols = defines{}
 	var asmFilename string
 	var objFilename string
 	var err error
 
 	flag.BoolVar(&verbose, "v", false, "Verbose")
+	flag.StringVar(&listingFilename, "l", "", "Write the listing to file")
+	flag.BoolVar(&object, "c", false, "Write a relocatable object (.o) without running it")
+	flag.Var(symbols, "D", "Define a symbol (NAME=value)")
 	flag.Parse()
 	if flag.NArg() == 0 {
 		fmt.Println("no input file")
 		os.Exit(2)
 	}
 	asmFilename = flag.Args()[0]
-	objFilename = fmt.Sprintf("%s.obj", asmFilename)
-	err = asm.Compile(asmFilename, objFilename, verbose)
+	if object {
+		objFilename = fmt.Sprintf("%s.o", strings.TrimSuffix(asmFilename, filepath.Ext(asmFilename)))
+	} else {
+		objFilename = fmt.Sprintf("%s.obj", asmFilename)
+	}
+	err = asm.CompileWithOptions(asmFilename, objFilename, asm.Options{Verbose: verbose, Listing: listingFilename, Object: object, Defines: symbols})
 	if err != nil {
-		fmt.Println(err)
-		return
+		asm.PrintError(os.Stderr, err)
+		os.Exit(1)
+	}
+	if !object {
+		run(objFilename, verbose)
 	}
-	run(objFilename, verbose)
 }
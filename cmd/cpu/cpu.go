@@ -8,29 +8,38 @@ import (
 )
 
 // Run obj file
-func run(objFilename string, verbose bool) {
+func run(objFilename string, verbose bool, heapDebug bool) {
 	cpu, err := fcpu.NewCPU(objFilename)
-	cpu.Verbose = verbose
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	cpu.Loop()
+	cpu.Verbose = verbose
+	if heapDebug {
+		cpu.Heap.Debug()
+	}
+	err = cpu.Loop()
 	if verbose {
 		cpu.PrintMemory()
 	}
+	if _, halt := err.(*fcpu.Halt); !halt {
+		fmt.Fprintln(os.Stderr, err) // unhandled trap or heap error
+		os.Exit(1)
+	}
 }
 
 func main() {
 	var verbose bool
+	var heapDebug bool
 	var objFilename string
 
 	flag.BoolVar(&verbose, "v", false, "Verbose")
+	flag.BoolVar(&heapDebug, "heap-debug", false, "Detect the heap double frees and out-of-bounds writes")
 	flag.Parse()
 	if flag.NArg() == 0 {
 		fmt.Println("no input file")
 		os.Exit(2)
 	}
 	objFilename = flag.Args()[0]
-	run(objFilename, verbose)
+	run(objFilename, verbose, heapDebug)
 }
@@ -6,48 +6,96 @@ import (
 	asm "github.com/andreax79/go-fcpu/pkg/assembler"
 	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
 	forth "github.com/andreax79/go-fcpu/pkg/forth"
+	system "github.com/andreax79/go-fcpu/pkg/system"
 	"os"
+	"path/filepath"
 )
 
 // Run obj file
-func run(objFilename string, verbose bool) {
+func run(objFilename string, verbose bool, heapDebug bool) {
 	cpu, err := fcpu.NewCPU(objFilename)
-	cpu.Verbose = verbose
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	cpu.Loop()
+	cpu.Verbose = verbose
+	if heapDebug {
+		cpu.Heap.Debug()
+	}
+	err = cpu.Loop()
 	if verbose {
 		cpu.PrintMemory()
 	}
+	if _, halt := err.(*fcpu.Halt); !halt {
+		fmt.Fprintln(os.Stderr, err) // unhandled trap or heap error
+		os.Exit(1)
+	}
+}
+
+// Build and boot the self-hosted Forth system, reading from the terminal
+func boot(verbose bool, heapDebug bool) {
+	tmpDir, err := os.MkdirTemp("", "forth")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+	objFilename := filepath.Join(tmpDir, "system.obj")
+	if err = system.Build(objFilename); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	run(objFilename, verbose, heapDebug)
+}
+
+// Interactive Forth, the history is saved in the home directory
+func repl() {
+	r := forth.NewRepl(os.Stdin, os.Stdout)
+	if home, err := os.UserHomeDir(); err == nil {
+		r.HistoryFile = filepath.Join(home, ".fcpu_forth_history")
+	}
+	fmt.Println("fcpu forth - type :help for the commands")
+	if err := r.Run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 func main() {
 	var verbose bool
+	var heapDebug bool
 	var forthFilename string
 	var asmFilename string
 	var objFilename string
 	var err error
 
 	flag.BoolVar(&verbose, "v", false, "Verbose")
+	flag.BoolVar(&heapDebug, "heap-debug", false, "Detect the heap double frees and out-of-bounds writes")
 	flag.Parse()
 	if flag.NArg() == 0 {
 		fmt.Println("no input file")
 		os.Exit(2)
 	}
+	switch flag.Arg(0) {
+	case "system":
+		boot(verbose, heapDebug)
+		return
+	case "repl":
+		repl()
+		return
+	}
 	forthFilename = flag.Args()[0]
 	asmFilename = fmt.Sprintf("%s.pal", forthFilename)
 	err = forth.Compile(forthFilename, asmFilename)
 	if err != nil {
-		fmt.Println(err)
-		return
+		asm.PrintError(os.Stderr, err)
+		os.Exit(1)
 	}
 	objFilename = fmt.Sprintf("%s.obj", forthFilename)
 	err = asm.Compile(asmFilename, objFilename, false)
 	if err != nil {
-		fmt.Println(err)
-		return
+		asm.PrintError(os.Stderr, err)
+		os.Exit(1)
 	}
-	run(objFilename, verbose)
+	run(objFilename, verbose, heapDebug)
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	linker "github.com/andreax79/go-fcpu/pkg/linker"
+	"os"
+)
+
+func main() {
+	var outputFilename string
+	var archiveFilename string
+	var err error
+
+	flag.StringVar(&outputFilename, "o", "a.obj", "Output executable")
+	flag.StringVar(&archiveFilename, "a", "", "Create an archive library instead of linking")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Println("no input file")
+		os.Exit(2)
+	}
+	if archiveFilename != "" {
+		err = linker.WriteArchiveFile(archiveFilename, flag.Args())
+	} else {
+		err = linker.Link(flag.Args(), outputFilename)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
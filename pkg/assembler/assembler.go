@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Assembler pass
@@ -29,6 +30,9 @@ const (
 	Byte
 	Asciz
 	Ascii
+	Utf8
+	Global
+	Extern
 )
 
 // Text segement address
@@ -51,6 +55,30 @@ type CompilerStatus struct {
 	labels  map[string]fcpu.Addr // map label names to addresses
 	pass    Pass                 // pass number (First/Second)
 	verbose bool                 // verbose
+	errors  ErrorList            // errors found during the pass
+	listing *Listing             // listing (optional)
+	pos     Pos                  // position of the current token
+	object  bool                 // relocatable object output
+
+	globals     map[string]Pos  // global symbols, map names to the position of the .global directive
+	externs     map[string]bool // symbols defined in other objects
+	relocations []relocation    // relocations (only for relocatable objects)
+
+	defines      map[string]fcpu.Word // symbols defined with -D or .equ
+	defined      map[string]bool      // labels defined before the current position
+	conditionals []*conditional       // open conditional assembly blocks
+}
+
+// Compiler options
+type Options struct {
+	Verbose bool   // print the compiled code
+	Listing string // listing filename (optional)
+	Object  bool   // write a relocatable object instead of an executable
+
+	Defines map[string]fcpu.Word // predefined symbols (for conditional assembly)
+
+	TextBase fcpu.Addr // base of text (default TextSegment)
+	DataBase fcpu.Addr // base of data (default DataSegment)
 }
 
 func NewCompilerStatus(pass Pass, labels map[string]fcpu.Addr, verbose bool) (status *CompilerStatus) {
@@ -66,6 +94,8 @@ func NewCompilerStatus(pass Pass, labels map[string]fcpu.Addr, verbose bool) (st
 	status.data.addr = status.data.start
 	status.data.buf = new(bytes.Buffer)
 	status.pass = pass
+	status.globals = map[string]Pos{}
+	status.externs = map[string]bool{}
 	if labels != nil {
 		status.labels = labels
 	} else {
@@ -74,16 +104,52 @@ func NewCompilerStatus(pass Pass, labels map[string]fcpu.Addr, verbose bool) (st
 	return status
 }
 
+// Return the name of the segment containing an address
+func (status *CompilerStatus) segmentName(addr fcpu.Addr) string {
+	if addr >= status.data.start {
+		return "data"
+	}
+	return "text"
+}
+
+// Add the bytes written in the current segment since the given offset to the listing
+func (status *CompilerStatus) addToListing(addr fcpu.Addr, offset int) {
+	if status.pass == Second && status.listing != nil {
+		status.listing.Add(status.pos.Line, addr, status.segment.buf.Bytes()[offset:])
+	}
+}
+
+// Add an error to the list of the errors, return false if the error is not a Diagnostic
+func (status *CompilerStatus) addDiagnostic(err error) bool {
+	var diagnostic Diagnostic
+	if errors.As(err, &diagnostic) {
+		status.errors.Add(diagnostic)
+		return true
+	}
+	return false
+}
+
+// Add a relocation for the word at the current address
+func (status *CompilerStatus) addRelocation(symbol string) {
+	status.relocations = append(status.relocations, relocation{
+		segment: status.segment,
+		offset:  status.segment.addr - status.segment.start,
+		symbol:  symbol,
+	})
+}
+
 // Add data to the program
 func (status *CompilerStatus) AddData(data fcpu.Word) error {
 	if status.pass == Second && status.verbose {
 		fmt.Printf("%04x %x\n", status.segment.addr, uint32(data))
 	}
+	addr, offset := status.segment.addr, status.segment.buf.Len()
 	err := binary.Write(status.segment.buf, binary.LittleEndian, data)
 	if err != nil {
 		return err
 	}
 	status.segment.addr += fcpu.WordSize
+	status.addToListing(addr, offset)
 	return nil
 }
 
@@ -92,8 +158,10 @@ func (status *CompilerStatus) AddBytes(bytes []byte) error {
 	if status.pass == Second && status.verbose {
 		fmt.Printf("%04x %v\n", status.segment.addr, bytes)
 	}
+	addr, offset := status.segment.addr, status.segment.buf.Len()
 	status.segment.buf.Write(bytes)
 	status.segment.addr += fcpu.Addr(len(bytes))
+	status.addToListing(addr, offset)
 	return nil
 }
 
@@ -102,32 +170,91 @@ func (status *CompilerStatus) AddCode(code ...fcpu.Op) error {
 	if status.pass == Second && status.verbose {
 		fmt.Printf("%04x %s\n", status.segment.addr, strings.Trim(fmt.Sprint(code), "[]"))
 	}
+	addr, offset := status.segment.addr, status.segment.buf.Len()
 	err := binary.Write(status.segment.buf, binary.LittleEndian, code)
 	if err != nil {
 		return err
 	}
 	status.segment.addr += fcpu.Addr(len(code)) * fcpu.OpSize
+	status.addToListing(addr, offset)
 	return nil
 }
 
 // Execute a compilation pass
 // Each source line contains some combination of the following fields:
 // label:    instructions/operands      ; comment
-func CompilePass(file *os.File, pass Pass, labels map[string]fcpu.Addr, verbose bool) (*CompilerStatus, error) {
-	status := NewCompilerStatus(pass, labels, verbose)
-	lexer := NewLexer(file)
+// The errors are collected and returned as an ErrorList at the end of the pass
+func CompilePass(file *os.File, pass Pass, labels map[string]fcpu.Addr, options Options) (*CompilerStatus, error) {
+	return compilePass(NewLexer(file), pass, labels, options)
+}
+
+// Execute a compilation pass reading the tokens from a lexer
+func compilePass(lexer *Lexer, pass Pass, labels map[string]fcpu.Addr, options Options) (*CompilerStatus, error) {
+	status := NewCompilerStatus(pass, labels, options.Verbose)
+	status.object = options.Object
+	if options.TextBase != 0 {
+		status.text.start, status.text.addr = options.TextBase, options.TextBase
+	}
+	if options.DataBase != 0 {
+		status.data.start, status.data.addr = options.DataBase, options.DataBase
+	}
+	status.defines = map[string]fcpu.Word{}
+	status.defined = map[string]bool{}
+	for name, value := range options.Defines {
+		status.defines[strings.ToUpper(name)] = value
+	}
+	if options.Listing != "" {
+		status.listing = new(Listing)
+	}
 	directive := None
+	directiveLine := 0 // line of the last .global or .extern directive
 	for {
 		token, err := lexer.NextToken()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				return status, nil
+				status.checkConditionals()
+				if status.pass != First {
+					status.checkGlobals()
+				}
+				return status, status.errors.Err()
+			}
+			var diagnostic Diagnostic
+			if errors.As(err, &diagnostic) {
+				if !status.skipping() {
+					status.errors.Add(diagnostic)
+				}
+				continue
 			}
 			return nil, err
 		}
+		status.pos = token.Pos
+
+		// Conditional assembly
+		if token.Type == DIRECTIVE && isConditional(token.Symbol) {
+			if err = status.conditionalDirective(lexer, token); err != nil {
+				if !status.addDiagnostic(err) {
+					return nil, err
+				}
+			}
+			directive = None
+			continue
+		}
+		if status.skipping() {
+			continue
+		}
 
 		switch token.Type {
 		case INSTRUCTION:
+			if fop, isFloat := FloatInstructions[token.Symbol]; isFloat {
+				err = status.AddCode(fcpu.FPU, fcpu.Op(fop))
+				directive = None
+				break
+			}
+			if xop, isExt := ExtInstructions[token.Symbol]; isExt {
+				err = status.AddCode(fcpu.EXT, fcpu.Op(xop))
+				directive = None
+				break
+			}
 			op := Instructions[token.Symbol]
 			if op == fcpu.PUSH {
 				// Align PUSH operand to word by inserting NOPs
@@ -153,20 +280,59 @@ func CompilePass(file *os.File, pass Pass, labels map[string]fcpu.Addr, verbose
 				directive = Word
 			case ".BYTE":
 				directive = Byte
-			case ".ASCIZ":
+			case ".ASCIZ", ".STRING":
 				directive = Asciz
 			case ".ASCII":
 				directive = Ascii
+			case ".UTF8":
+				directive = Utf8
+			case ".GLOBAL", ".GLOBL":
+				directive = Global
+				directiveLine = token.Line
+			case ".EXTERN":
+				directive = Extern
+				directiveLine = token.Line
+			case ".SPACE", ".ZERO": // reserve zero-filled bytes
+				if err = status.spaceDirective(lexer, token); err != nil {
+					if !status.addDiagnostic(err) {
+						return nil, err
+					}
+				}
+				directive = None
+			case ".EQU", ".SET": // define a symbol
+				if err = status.equDirective(lexer, token); err != nil {
+					if !status.addDiagnostic(err) {
+						return nil, err
+					}
+				}
+				directive = None
 			default:
-				return nil, &UndefinedDirective{Label: token.Symbol, Line: token.Line}
+				status.errors.Add(&UndefinedDirective{Label: token.Symbol, Pos: token.Pos})
+				directive = None
 			}
 
 		case IDENTIFIER:
-			// Ignore undefined identifier during the first compilation pass
-			if status.pass != First {
+			if (directive == Global || directive == Extern) && token.Line != directiveLine {
+				directive = None // the symbols are on the line of the directive
+			}
+			switch directive {
+			case Global: // .global symbol [symbol...]
+				if _, exists := status.globals[token.Symbol]; !exists {
+					status.globals[token.Symbol] = token.Pos
+				}
+				continue
+			case Extern: // .extern symbol [symbol...]
+				status.externs[token.Symbol] = true
+				continue
+			}
+			if value, isDefine := status.defines[token.Symbol]; isDefine {
+				err = status.AddData(value)
+			} else if status.pass != First { // Ignore undefined identifier during the first compilation pass
 				label, exists := status.labels[token.Symbol]
-				if !exists {
-					return nil, &UndefinedSymbol{Label: token.Symbol, Line: token.Line}
+				if status.object && (exists || status.externs[token.Symbol]) {
+					status.addRelocation(token.Symbol)
+				} else if !exists {
+					status.errors.Add(&UndefinedSymbol{Label: token.Symbol, Pos: token.Pos})
 				}
 				err = status.AddData(fcpu.Word(label))
 			} else {
@@ -176,15 +342,17 @@ func CompilePass(file *os.File, pass Pass, labels map[string]fcpu.Addr, verbose
 
 		case LABEL:
 			if _, exists := status.labels[token.Symbol]; exists && status.pass == First {
-				return nil, &LabelMultipleDefinition{Label: token.Symbol, Line: token.Line}
+				status.errors.Add(&LabelMultipleDefinition{Label: token.Symbol, Pos: token.Pos})
+			} else {
+				status.labels[token.Symbol] = status.segment.addr
 			}
-			status.labels[token.Symbol] = status.segment.addr
+			status.defined[token.Symbol] = true
 			directive = None
 
 		case NUMBER:
-			value, err := strconv.ParseInt(token.Symbol, 0, 0)
-			if err != nil {
-				return nil, err
+			value, parseErr := strconv.ParseInt(token.Symbol, 0, 0)
+			if parseErr != nil {
+				status.errors.Add(&InvalidNumber{Number: token.Symbol, Pos: token.Pos})
 			}
 			switch directive {
 			case Byte:
@@ -194,7 +362,7 @@ func CompilePass(file *os.File, pass Pass, labels map[string]fcpu.Addr, verbose
 			case Word:
 				err = status.AddData(fcpu.Word(value))
 			default:
-				return nil, &UnexpectedToken{Token: token.Symbol, Line: token.Line}
+				status.errors.Add(&UnexpectedToken{Token: token.Symbol, Pos: token.Pos})
 			}
 
 		case STRING:
@@ -203,12 +371,19 @@ func CompilePass(file *os.File, pass Pass, labels map[string]fcpu.Addr, verbose
 				err = status.AddBytes([]byte(token.Symbol + string(rune(0))))
 			case Ascii:
 				err = status.AddBytes([]byte(token.Symbol))
+			case Utf8:
+				if !utf8.ValidString(token.Symbol) {
+					status.errors.Add(&InvalidUTF8{Pos: token.Pos})
+				}
+				err = status.AddBytes([]byte(token.Symbol))
 			default:
-				return nil, &UnexpectedToken{Token: token.Symbol, Line: token.Line}
+				status.errors.Add(&UnexpectedToken{Token: fmt.Sprintf("%q", token.Symbol), Pos: token.Pos})
 			}
 		}
+		if err != nil {
+			return nil, err
+		}
 	}
-	return status, nil
 }
 
 func WriteBinary(status *CompilerStatus, outputFilename string) error {
@@ -244,22 +419,69 @@ func WriteBinary(status *CompilerStatus, outputFilename string) error {
 
 // Compile a program file and return the compiled code
 func Compile(filename string, outputFilename string, verbose bool) error {
+	return CompileWithOptions(filename, outputFilename, Options{Verbose: verbose})
+}
+
+// Compile a program file with the given options
+func CompileWithOptions(filename string, outputFilename string, options Options) error {
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-
-	// First pass
-	var status *CompilerStatus
-	if status, err = CompilePass(file, First, nil, verbose); err != nil {
+	status, err := assemble(func() *Lexer {
+		file.Seek(0, 0) // rewind
+		return NewLexer(file)
+	}, options)
+	if err != nil {
 		return err
 	}
-	// Second pass
-	file.Seek(0, 0) // rewind
-	if status, err = CompilePass(file, Second, status.labels, verbose); err != nil {
-		return err
+	// Write listing
+	if options.Listing != "" {
+		if err = WriteListing(status, filename, options.Listing); err != nil {
+			return err
+		}
 	}
 	// Write output
+	if options.Object {
+		return WriteObject(status, outputFilename)
+	}
 	return WriteBinary(status, outputFilename)
 }
+
+// Assemble a source in memory into a relocatable object, the name is used in the error positions
+func AssembleObject(name string, source string, options Options) (*Object, error) {
+	options.Object = true
+	status, err := assemble(func() *Lexer {
+		return NewReaderLexer(name, strings.NewReader(source))
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	return NewObject(status), nil
+}
+
+// Execute the two compilation passes, newLexer returns a lexer reading the source from the start
+func assemble(newLexer func() *Lexer, options Options) (*CompilerStatus, error) {
+	// First pass
+	var errs ErrorList
+	status, err := compilePass(newLexer(), First, nil, options)
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+	// Second pass, executed even if the first one failed in order to report all the errors
+	if status, err = compilePass(newLexer(), Second, status.labels, options); err != nil {
+		var secondPassErrs ErrorList
+		if !errors.As(err, &secondPassErrs) {
+			return nil, err
+		}
+		for _, e := range secondPassErrs {
+			errs.Add(e)
+		}
+	}
+	if len(errs) != 0 {
+		errs.Sort()
+		return nil, errs
+	}
+	return status, nil
+}
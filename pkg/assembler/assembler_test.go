@@ -7,18 +7,21 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 var Halt = new(fcpu.Halt)
 
 func runAsm(source string) (*fcpu.CPU, error) {
+	return runAsmWithOptions(source, Options{})
+}
+
+func runAsmWithOptions(source string, options Options) (*fcpu.CPU, error) {
 	var err error
 	var tmpDir string
 	var asmFilename string
 	var objFilename string
-	var verbose bool
-	verbose = false
 	// Create temp directory
 	tmpDir, err = os.MkdirTemp("", "test")
 	if err != nil {
@@ -32,7 +35,7 @@ func runAsm(source string) (*fcpu.CPU, error) {
 	}
 	// Asm => bytecode
 	objFilename = fmt.Sprintf("%s.obj", asmFilename)
-	err = Compile(asmFilename, objFilename, verbose)
+	err = CompileWithOptions(asmFilename, objFilename, options)
 	if err != nil {
 		return nil, err
 	}
@@ -92,3 +95,204 @@ func TestRShift(t *testing.T) {
 		[]fcpu.Word{1, 0, 1, 1},
 	)
 }
+
+func TestUnsigned(t *testing.T) {
+	testAsm(t,
+		`push -8 push 1 rshift
+		 push -8 push 1 arshift
+		 push -1 push 1 ult
+		 push -1 push 1 ugt
+		 push -2 push 2 udiv
+		 push -1 push 16 umod`,
+		[]fcpu.Word{0x7ffffffc, -4, 0, -1, 0x7fffffff, 15},
+	)
+}
+
+func TestFloat(t *testing.T) {
+	testAsm(t,
+		`push 7 itof push 2 itof fdiv push 10 itof fmul ftoi
+		 push 9 itof fsqrt fpop
+		 push 1 itof push 2 itof flt fdepth`,
+		[]fcpu.Word{35, 0x40400000, -1, 0},
+	)
+}
+
+func TestStackRegisters(t *testing.T) {
+	testAsm(t,
+		`push 1 pushsp pushsb
+		 push 5 push 6 pushsp push 4 add popsp`,
+		[]fcpu.Word{1, fcpu.DataStackTop - 4, fcpu.DataStackTop, 5},
+	)
+}
+
+func TestErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	asmFilename := filepath.Join(tmpDir, "source.pal")
+	source := `push undefined
+	.unknown 1
+label: push 1 @ add
+label:`
+	if err := os.WriteFile(asmFilename, []byte(source), 0666); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := Compile(asmFilename, asmFilename+".obj", false)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("error list expected, got: %v", err)
+	}
+	expected := []struct {
+		Line   int
+		Column int
+	}{
+		{1, 6}, {2, 2}, {3, 15}, {4, 1},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), errs)
+	}
+	for i, pos := range expected {
+		if errs[i].Position().Line != pos.Line || errs[i].Position().Column != pos.Column {
+			t.Fatalf("expected %d:%d got: %s", pos.Line, pos.Column, errs[i].Position())
+		}
+	}
+	var buf strings.Builder
+	errs.Print(&buf)
+	lines := strings.Split(buf.String(), "\n")
+	if lines[0] != asmFilename+":1:6: error: Undefined symbol UNDEFINED" ||
+		lines[1] != "push undefined" || lines[2] != "     ^" ||
+		lines[4] != "\t.unknown 1" || lines[5] != "\t^" {
+		t.Fatalf("unexpected diagnostic:\n%s", buf.String())
+	}
+}
+
+func TestListing(t *testing.T) {
+	tmpDir := t.TempDir()
+	asmFilename := filepath.Join(tmpDir, "source.pal")
+	listingFilename := filepath.Join(tmpDir, "source.lst")
+	source := `start: push 10 dup add
+	; comment
+	hlt
+.data
+msg: .asciz "Hello, world"
+`
+	if err := os.WriteFile(asmFilename, []byte(source), 0666); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := CompileWithOptions(asmFilename, asmFilename+".obj", Options{Listing: listingFilename})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	data, err := os.ReadFile(listingFilename)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	listing := string(data)
+	expected := []string{
+		"    1  08048100  01 01 01 04 0a 00 00 00  start: push 10 dup add\n" +
+			"       08048108  46 90\n",
+		"    2                                     \t; comment\n",
+		"    3  0804810a  00                       \thlt\n",
+		"    5  08074000  48 65 6c 6c 6f 2c 20 77  msg: .asciz \"Hello, world\"\n" +
+			"       08074008  6f 72 6c 64 00\n",
+		"  MSG                              08074000  data\n  START                            08048100  text\n",
+		"  08048100  START                            text\n  08074000  MSG                              data\n",
+		"  text  08048100        11 bytes\n  data  08074000        13 bytes\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(listing, s) {
+			t.Fatalf("expected:\n%s\nin listing:\n%s", s, listing)
+		}
+	}
+}
+
+func TestConditional(t *testing.T) {
+	cpu, err := runAsmWithOptions(`
+.equ DISK 1
+.ifdef DISK
+    push 1
+.else
+    push 2
+.endif
+.ifndef disk push 3 .else push 4 .endif
+.if MEMORY
+    push MEMORY
+.endif
+.if 0
+  .if 1 push 5 .else push 6 .endif
+.else
+  .if DISK push 7 .endif
+.endif
+.ifdef later push 8 .endif
+later:
+.ifdef later push 9 .endif
+.ifdef undefined push 10 .endif`,
+		Options{Defines: map[string]fcpu.Word{"memory": 64}},
+	)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := []fcpu.Word{1, 4, 64, 7, 9}
+	if !reflect.DeepEqual(cpu.Ds.Array(), expected) {
+		t.Fatalf("Wrong stack content: %d expected: %d", cpu.Ds.Array(), expected)
+	}
+}
+
+func TestConditionalErrors(t *testing.T) {
+	_, err := runAsm(`.else
+.endif
+.if
+push 1
+.if 1 .else .else .endif
+.ifdef X`)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("error list expected, got: %v", err)
+	}
+	expected := []string{
+		"1:1: error: Unbalanced conditional directive .ELSE",
+		"2:1: error: Unbalanced conditional directive .ENDIF",
+		"3:1: error: Missing operand for .IF",
+		"3:1: error: Unterminated conditional directive, .endif expected",
+		"5:13: error: Unbalanced conditional directive .ELSE",
+		"6:1: error: Unterminated conditional directive, .endif expected",
+	}
+	lines := strings.Split(errs.Error(), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), errs)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, expected[i]) {
+			t.Fatalf("expected: %s got: %s", expected[i], line)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	testAsm(t,
+		`push 'A' push '\n'
+		 push msg fetch_b push msg push 2 add fetch_b push msg push 3 add fetch_b
+		 push utf fetch_b
+		 .data
+		 msg: .string "\x41B\xff"
+		 utf: .utf8 "è"
+		 .text`,
+		[]fcpu.Word{65, 10, 65, 255, 0, 0xc3},
+	)
+	_, err := runAsm(`.data .utf8 "\xff"`)
+	if err == nil || !strings.Contains(err.Error(), "Invalid UTF-8 string") {
+		t.Fatalf("invalid UTF-8 error expected, got: %v", err)
+	}
+}
+
+func TestAssembleObject(t *testing.T) {
+	obj, err := AssembleObject("unit.pal", "start: push value fetch hlt\n.data\nvalue: .word 42\n", Options{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(obj.Data) != 4 || len(obj.Relocations) != 1 || obj.Symbols[len(obj.Symbols)-1].Name != "VALUE" {
+		t.Fatalf("unexpected object: %+v", obj)
+	}
+	_, err = AssembleObject("unit.pal", "push undefined", Options{})
+	if err == nil || !strings.HasPrefix(err.Error(), "unit.pal:1:6:") {
+		t.Fatalf("undefined symbol at unit.pal:1:6 expected, got: %v", err)
+	}
+}
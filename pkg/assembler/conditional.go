@@ -0,0 +1,95 @@
+package assembler
+
+// Conditional assembly block (.if/.ifdef/.ifndef ... .else ... .endif)
+type conditional struct {
+	pos    Pos  // position of the opening directive
+	active bool // the current branch is assembled
+	taken  bool // a branch of the block has been assembled
+	isElse bool // the .else branch has been reached
+}
+
+// Check if a directive is a conditional assembly directive
+func isConditional(directive string) bool {
+	switch directive {
+	case ".IF", ".IFDEF", ".IFNDEF", ".ELSE", ".ENDIF":
+		return true
+	}
+	return false
+}
+
+// Check if the current tokens must be skipped
+func (status *CompilerStatus) skipping() bool {
+	for _, c := range status.conditionals {
+		if !c.active {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a symbol is defined (by -D, .equ or by a label before the current position)
+func (status *CompilerStatus) isDefined(symbol string) bool {
+	_, isDefine := status.defines[symbol]
+	return isDefine || status.defined[symbol]
+}
+
+// Process a conditional assembly directive
+func (status *CompilerStatus) conditionalDirective(lexer *Lexer, token *Token) error {
+	switch token.Symbol {
+	case ".IF", ".IFDEF", ".IFNDEF":
+		c := &conditional{pos: token.Pos}
+		operand, err := status.readOperand(lexer, token)
+		if err != nil {
+			// Skip the whole block
+			c.taken = true
+			status.conditionals = append(status.conditionals, c)
+			return err
+		}
+		if status.skipping() {
+			// Nested block in a skipped block, the operand is not evaluated
+			c.taken = true
+		} else {
+			switch token.Symbol {
+			case ".IF":
+				value, err := status.evaluate(operand)
+				if err != nil {
+					status.errors.Add(err.(Diagnostic))
+				}
+				c.active = value != 0
+			case ".IFDEF":
+				c.active = status.isDefined(operand.Symbol)
+			case ".IFNDEF":
+				c.active = !status.isDefined(operand.Symbol)
+			}
+			c.taken = c.active
+		}
+		status.conditionals = append(status.conditionals, c)
+
+	case ".ELSE":
+		if len(status.conditionals) == 0 {
+			return &UnbalancedConditional{Directive: token.Symbol, Pos: token.Pos}
+		}
+		c := status.conditionals[len(status.conditionals)-1]
+		if c.isElse {
+			return &UnbalancedConditional{Directive: token.Symbol, Pos: token.Pos}
+		}
+		c.isElse = true
+		c.active = !c.taken
+		c.taken = true
+
+	case ".ENDIF":
+		if len(status.conditionals) == 0 {
+			return &UnbalancedConditional{Directive: token.Symbol, Pos: token.Pos}
+		}
+		status.conditionals = status.conditionals[:len(status.conditionals)-1]
+	}
+	return nil
+}
+
+// Check that all the conditional blocks are closed
+func (status *CompilerStatus) checkConditionals() {
+	for _, c := range status.conditionals {
+		status.errors.Add(&UnterminatedConditional{Pos: c.pos})
+	}
+	status.conditionals = nil
+}
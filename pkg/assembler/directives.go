@@ -0,0 +1,79 @@
+package assembler
+
+import (
+	"errors"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"strconv"
+)
+
+// Read the operand of a directive
+func (status *CompilerStatus) readOperand(lexer *Lexer, directive *Token) (*Token, error) {
+	token, err := lexer.NextToken()
+	if errors.Is(err, io.EOF) {
+		return nil, &MissingOperand{Directive: directive.Symbol, Pos: directive.Pos}
+	} else if err != nil {
+		return nil, err
+	}
+	if token.Line != directive.Line {
+		lexer.UnreadToken(token)
+		return nil, &MissingOperand{Directive: directive.Symbol, Pos: directive.Pos}
+	}
+	return token, nil
+}
+
+// Return the value of a number or of a defined symbol
+func (status *CompilerStatus) evaluate(token *Token) (fcpu.Word, error) {
+	switch token.Type {
+	case NUMBER:
+		value, err := strconv.ParseInt(token.Symbol, 0, 0)
+		if err != nil {
+			return 0, &InvalidNumber{Number: token.Symbol, Pos: token.Pos}
+		}
+		return fcpu.Word(value), nil
+	case IDENTIFIER, INSTRUCTION:
+		value, exists := status.defines[token.Symbol]
+		if !exists {
+			return 0, &UndefinedSymbol{Label: token.Symbol, Pos: token.Pos}
+		}
+		return value, nil
+	}
+	return 0, &UnexpectedToken{Token: token.Symbol, Pos: token.Pos}
+}
+
+// Process a .equ/.set directive (.equ symbol value)
+func (status *CompilerStatus) equDirective(lexer *Lexer, token *Token) error {
+	name, err := status.readOperand(lexer, token)
+	if err != nil {
+		return err
+	}
+	if name.Type != IDENTIFIER && name.Type != INSTRUCTION {
+		return &UnexpectedToken{Token: name.Symbol, Pos: name.Pos}
+	}
+	operand, err := status.readOperand(lexer, token)
+	if err != nil {
+		return err
+	}
+	value, err := status.evaluate(operand)
+	if err != nil {
+		return err
+	}
+	status.defines[name.Symbol] = value
+	return nil
+}
+
+// Process a .space directive (.space size)
+func (status *CompilerStatus) spaceDirective(lexer *Lexer, token *Token) error {
+	operand, err := status.readOperand(lexer, token)
+	if err != nil {
+		return err
+	}
+	size, err := status.evaluate(operand)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return &InvalidNumber{Number: operand.Symbol, Pos: operand.Pos}
+	}
+	return status.AddBytes(make([]byte, size))
+}
@@ -3,42 +3,227 @@ package assembler
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
 )
 
+// Position in a source file
+type Pos struct {
+	File   string
+	Line   int // line number, starting at 1
+	Column int // column number (in characters), starting at 1
+}
+
+func (pos Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+}
+
+// Return the position (implements Diagnostic)
+func (pos Pos) Position() Pos {
+	return pos
+}
+
+// Error with a position in the source
+type Diagnostic interface {
+	error
+	Position() Pos
+}
+
 type UndefinedSymbol struct {
 	Label string
-	Line  int
+	Pos
 }
 
 func (e *UndefinedSymbol) Error() string {
-	return fmt.Sprintf("Undefined symbol %s in line %d", e.Label, e.Line)
+	return fmt.Sprintf("Undefined symbol %s", e.Label)
 }
 
 type UndefinedDirective struct {
 	Label string
-	Line  int
+	Pos
 }
 
 func (e *UndefinedDirective) Error() string {
-	return fmt.Sprintf("Undefined directive %s in line %d", e.Label, e.Line)
+	return fmt.Sprintf("Undefined directive %s", e.Label)
 }
 
 type LabelMultipleDefinition struct {
 	Label string
-	Line  int
+	Pos
 }
 
 func (e *LabelMultipleDefinition) Error() string {
-	return fmt.Sprintf("Multiple definition of a label %s in line %d", e.Label, e.Line)
+	return fmt.Sprintf("Multiple definition of a label %s", e.Label)
 }
 
 type UnexpectedToken struct {
 	Token string
-	Line  int
+	Pos
 }
 
 func (e *UnexpectedToken) Error() string {
-	return fmt.Sprintf("Unexpected token %s in line %d", e.Token, e.Line)
+	return fmt.Sprintf("Unexpected token %s", e.Token)
+}
+
+type InvalidNumber struct {
+	Number string
+	Pos
+}
+
+func (e *InvalidNumber) Error() string {
+	return fmt.Sprintf("Invalid number %s", e.Number)
+}
+
+type UnmatchedDelimiter struct {
+	Delimiter string
+	Pos
+}
+
+func (e *UnmatchedDelimiter) Error() string {
+	return fmt.Sprintf("Unmatched delimiter %s", e.Delimiter)
+}
+
+type InvalidEscape struct {
+	Escape string
+	Pos
+}
+
+func (e *InvalidEscape) Error() string {
+	return fmt.Sprintf("Invalid escape sequence %s", e.Escape)
+}
+
+type InvalidCharacter struct {
+	Literal string
+	Pos
+}
+
+func (e *InvalidCharacter) Error() string {
+	return fmt.Sprintf("Invalid character literal %s", e.Literal)
+}
+
+type InvalidUTF8 struct {
+	Pos
 }
 
-var UnmatechedDelimiter = errors.New("Unmateched delimiter")
+func (e *InvalidUTF8) Error() string {
+	return "Invalid UTF-8 string"
+}
+
+type MissingOperand struct {
+	Directive string
+	Pos
+}
+
+func (e *MissingOperand) Error() string {
+	return fmt.Sprintf("Missing operand for %s", e.Directive)
+}
+
+type UnbalancedConditional struct {
+	Directive string
+	Pos
+}
+
+func (e *UnbalancedConditional) Error() string {
+	return fmt.Sprintf("Unbalanced conditional directive %s", e.Directive)
+}
+
+type UnterminatedConditional struct {
+	Pos
+}
+
+func (e *UnterminatedConditional) Error() string {
+	return "Unterminated conditional directive, .endif expected"
+}
+
+// List of errors collected during the compilation
+type ErrorList []Diagnostic
+
+// Add an error to the list, ignoring duplicates (same position and message)
+func (list *ErrorList) Add(err Diagnostic) {
+	for _, e := range *list {
+		if e.Position() == err.Position() && e.Error() == err.Error() {
+			return
+		}
+	}
+	*list = append(*list, err)
+}
+
+// Sort the errors by position
+func (list ErrorList) Sort() {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].Position(), list[j].Position()
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// Return the list as an error, or nil if the list is empty
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+func (list ErrorList) Error() string {
+	var buf strings.Builder
+	for i, err := range list {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "%s: error: %s", err.Position(), err)
+	}
+	return buf.String()
+}
+
+// Print the errors, each one followed by the offending source line and a caret
+func (list ErrorList) Print(w io.Writer) {
+	sources := map[string][]string{}
+	for _, err := range list {
+		pos := err.Position()
+		fmt.Fprintf(w, "%s: error: %s\n", pos, err)
+		lines, loaded := sources[pos.File]
+		if !loaded {
+			if data, err := os.ReadFile(pos.File); err == nil {
+				lines = strings.Split(string(data), "\n")
+			}
+			sources[pos.File] = lines
+		}
+		if pos.Line < 1 || pos.Line > len(lines) {
+			continue
+		}
+		line := strings.TrimRight(lines[pos.Line-1], "\r")
+		fmt.Fprintf(w, "%s\n", line)
+		// Keep the tabs, so that the caret is aligned with the source line
+		var caret strings.Builder
+		for i, ch := range []rune(line) {
+			if i >= pos.Column-1 {
+				break
+			}
+			if ch == '\t' {
+				caret.WriteRune('\t')
+			} else {
+				caret.WriteRune(' ')
+			}
+		}
+		caret.WriteRune('^')
+		fmt.Fprintf(w, "%s\n", caret.String())
+	}
+}
+
+// Print an error; error lists are printed with the source snippets
+func PrintError(w io.Writer, err error) {
+	var list ErrorList
+	if errors.As(err, &list) {
+		list.Print(w)
+	} else {
+		fmt.Fprintln(w, err)
+	}
+}
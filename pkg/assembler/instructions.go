@@ -67,8 +67,92 @@ var Instructions = map[string]fcpu.Op{
 	/* Registers */
 	"PUSHRSP": fcpu.PUSHRSP, // Push RSP
 	"POPRSP":  fcpu.POPRSP,  // Pop -> RSP
-	"PUSHRBP": fcpu.PUSHRSP, // Push RBP
+	"PUSHRBP": fcpu.PUSHRBP, // Push RBP
 	"POPRBP":  fcpu.POPRBP,  // Pop -> RBP
 	"PUSHPC":  fcpu.PUSHPC,  // Push PC
 	"POPPC":   fcpu.JMP,     // Pop -> PC ( = JMP)
+
+	/* Input */
+	"KEY": fcpu.KEY, // Read a char
+
+	/* Double-cell arithmetic */
+	"ADDC":  fcpu.ADDC,  // Add with carry
+	"UMUL":  fcpu.UMUL,  // Unsigned multiply, double-cell result
+	"MMUL":  fcpu.MMUL,  // Signed multiply, double-cell result
+	"UMDIV": fcpu.UMDIV, // Unsigned divide double-cell by cell
+	"SMDIV": fcpu.SMDIV, // Signed symmetric divide double-cell by cell
+
+	/* Unsigned arithmetic and comparison */
+	"ARSHIFT": fcpu.ARSHIFT, // Perform an arithmetic right shift
+	"UDIV":    fcpu.UDIV,    // Unsigned divide
+	"UMOD":    fcpu.UMOD,    // Unsigned modulo
+	"UGT":     fcpu.UGT,     // Unsigned compare for Greater
+	"ULT":     fcpu.ULT,     // Unsigned compare for Less
+}
+
+// Floating-point instructions, encoded as the FPU opcode followed by the floating-point operation
+var FloatInstructions = map[string]fcpu.FOp{
+	/* Memory and transfer */
+	"FLOAD":  fcpu.FLOAD,  // Fetch a float
+	"FSTORE": fcpu.FSTORE, // Store a float
+	"FPUSH":  fcpu.FPUSH,  // Move the bits of a cell to the floating-point stack
+	"FPOP":   fcpu.FPOP,   // Move the bits of a float to the data stack
+
+	/* Stack manipulation */
+	"FDUP":   fcpu.FDUP,
+	"FDROP":  fcpu.FDROP,
+	"FSWAP":  fcpu.FSWAP,
+	"FOVER":  fcpu.FOVER,
+	"FDEPTH": fcpu.FDEPTH,
+
+	/* Arithmetic */
+	"FADD": fcpu.FADD,
+	"FSUB": fcpu.FSUB,
+	"FMUL": fcpu.FMUL,
+	"FDIV": fcpu.FDIV,
+	"FNEG": fcpu.FNEG,
+	"FABS": fcpu.FABS,
+	"FMAX": fcpu.FMAX,
+	"FMIN": fcpu.FMIN,
+
+	/* Comparison */
+	"FEQ": fcpu.FEQ,
+	"FLT": fcpu.FLT,
+
+	/* Conversion */
+	"ITOF":   fcpu.ITOF,   // Convert a number to float
+	"FTOI":   fcpu.FTOI,   // Convert a float to number
+	"DTOF":   fcpu.DTOF,   // Convert a double-cell number to float
+	"FTOD":   fcpu.FTOD,   // Convert a float to double-cell number
+	"FFLOOR": fcpu.FFLOOR, // Round toward negative infinity
+	"FROUND": fcpu.FROUND, // Round to the nearest integer
+	"FTRUNC": fcpu.FTRUNC, // Round toward zero
+
+	/* Functions */
+	"FSQRT":  fcpu.FSQRT,
+	"FSIN":   fcpu.FSIN,
+	"FCOS":   fcpu.FCOS,
+	"FTAN":   fcpu.FTAN,
+	"FATAN2": fcpu.FATAN2,
+	"FEXP":   fcpu.FEXP,
+	"FLN":    fcpu.FLN,
+	"FPOW":   fcpu.FPOW,
+
+	/* Output */
+	"FPRINT": fcpu.FPRINT, // Display a float
+}
+
+// Extended instructions, encoded as the EXT opcode followed by the extended operation
+var ExtInstructions = map[string]fcpu.XOp{
+	/* Memory blocks */
+	"BMOVE":     fcpu.BMOVE,     // Copy a block (the blocks can overlap)
+	"BMOVEUP":   fcpu.BMOVEUP,   // Copy a block, from lower to higher addresses
+	"BMOVEDOWN": fcpu.BMOVEDOWN, // Copy a block, from higher to lower addresses
+	"BFILL":     fcpu.BFILL,     // Fill a block with a byte
+
+	/* Data stack registers */
+	"PUSHSP": fcpu.PUSHSP, // Push the data stack pointer
+	"POPSP":  fcpu.POPSP,  // Pop -> data stack pointer
+	"PUSHSB": fcpu.PUSHSB, // Push the data stack base
+	"POPSB":  fcpu.POPSB,  // Pop -> data stack base
 }
@@ -2,10 +2,14 @@ package assembler
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Token Type
@@ -30,9 +34,11 @@ func isWhitespace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
-// Test if char octal/decimal/hex digit
+// Test if char binary/octal/decimal/hex digit
 func isDigit(ch rune, base int) bool {
 	switch base {
+	case 2: // binary
+		return ch == '0' || ch == '1'
 	case 8: // octal
 		return '0' <= ch && ch <= '7'
 	case 10: // decimal
@@ -48,46 +54,71 @@ func isDigit(ch rune, base int) bool {
 type Token struct {
 	Type   Type
 	Symbol string
-	Line   int
+	Pos
 }
 
 // Return a new token
-func newToken(tokenType Type, symbol string, line int) *Token {
-	return &Token{Type: tokenType, Symbol: symbol, Line: line}
+func newToken(tokenType Type, symbol string, pos Pos) *Token {
+	return &Token{Type: tokenType, Symbol: symbol, Pos: pos}
 }
 
 type Lexer struct {
 	reader *bufio.Reader
 	ch     rune
-	line   int
+	pos    Pos    // position of the current char
+	unread *Token // token returned by the next call of NextToken
 }
 
 // Return a new lexer
 func NewLexer(file *os.File) *Lexer {
+	return NewReaderLexer(file.Name(), file)
+}
+
+// Return a new lexer reading from a reader, the name is used in the positions
+func NewReaderLexer(name string, reader io.Reader) *Lexer {
 	lexer := new(Lexer)
-	lexer.reader = bufio.NewReader(file)
+	lexer.reader = bufio.NewReader(reader)
+	lexer.pos = Pos{File: name, Line: 1, Column: 0}
 	lexer.readRune()
 	return lexer
 }
 
-// Reads a single UTF-8 character
+// Reads a single UTF-8 character, at the end of file the current char is set to 0
 func (l *Lexer) readRune() error {
 	var err error
+	prev := l.ch
 	l.ch, _, err = l.reader.ReadRune()
+	if prev == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
 	if err != nil {
 		l.ch = rune(0)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
 		return err
 	}
-	if l.ch == '\n' {
-		l.line++
-	}
 	return nil
 }
 
+// Push back a token, it will be returned by the next call of NextToken
+func (l *Lexer) UnreadToken(token *Token) {
+	l.unread = token
+}
+
 // Return the next token
 func (l *Lexer) NextToken() (*Token, error) {
 	var err error
 
+	if l.unread != nil {
+		token := l.unread
+		l.unread = nil
+		return token, nil
+	}
+
 	// Skip whitespaces
 	if err = l.skipWhitespace(); err != nil {
 		return nil, err
@@ -111,6 +142,9 @@ func (l *Lexer) NextToken() (*Token, error) {
 	case l.ch == '"': // String
 		return l.readString()
 
+	case l.ch == '\'': // Character
+		return l.readChar()
+
 	case l.ch == '.': // Directive
 		return l.readDirective()
 
@@ -121,7 +155,12 @@ func (l *Lexer) NextToken() (*Token, error) {
 		return l.readIdentifier()
 
 	default:
-		return nil, &UnexpectedToken{Token: string(l.ch), Line: l.line}
+		// Consume the unexpected char, so that the lexer can continue
+		err := &UnexpectedToken{Token: string(l.ch), Pos: l.pos}
+		if err := l.readRune(); err != nil {
+			return nil, err
+		}
+		return nil, err
 	}
 }
 
@@ -148,7 +187,7 @@ func (l *Lexer) skipComment() error {
 // Read a directive
 func (l *Lexer) readDirective() (*Token, error) {
 	var buf strings.Builder
-	line := l.line
+	pos := l.pos
 	buf.WriteRune(l.ch)
 	if err := l.readRune(); err != nil {
 		return nil, err
@@ -159,87 +198,228 @@ func (l *Lexer) readDirective() (*Token, error) {
 			return nil, err
 		}
 	}
-	return newToken(DIRECTIVE, strings.ToUpper(buf.String()), line), nil
+	return newToken(DIRECTIVE, strings.ToUpper(buf.String()), pos), nil
 }
 
 // Read a number
+// Numbers can be decimal, hex (0x), octal (0o) or binary (0b), with optional _ digit separators
 func (l *Lexer) readNumber() (*Token, error) {
 	var buf strings.Builder
-	line := l.line
+	pos := l.pos
 	base := 10 // default base (decimal)
-	for i := 0; true; i++ {
-		if i == 0 && l.ch == '-' { // negative numbers
-			buf.WriteRune(l.ch)
-		} else if i == 1 && l.ch == 'x' { // 0x
+	// Read a char and add it to the buffer
+	next := func() error {
+		buf.WriteRune(l.ch)
+		return l.readRune()
+	}
+	if l.ch == '-' { // negative numbers
+		if err := next(); err != nil {
+			return nil, err
+		}
+	}
+	if l.ch == '0' {
+		if err := next(); err != nil {
+			return nil, err
+		}
+		switch l.ch {
+		case 'x', 'X': // 0x
 			base = 16 // hex
-			buf.WriteRune(l.ch)
-		} else if i == 1 && l.ch == 'o' { // 0o
+		case 'o', 'O': // 0o
 			base = 8 // octal
-			buf.WriteRune(l.ch)
-		} else if isDigit(l.ch, base) {
-			buf.WriteRune(l.ch)
-		} else {
-			break
+		case 'b', 'B': // 0b
+			base = 2 // binary
 		}
-		if err := l.readRune(); err != nil {
+		if base != 10 {
+			if err := next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for isDigit(l.ch, base) || l.ch == '_' {
+		if err := next(); err != nil {
 			return nil, err
 		}
 	}
-	return newToken(NUMBER, buf.String(), line), nil
+	return newToken(NUMBER, buf.String(), pos), nil
 }
 
-// Read a quoted string
-func (l *Lexer) readString() (*Token, error) {
-	var buf strings.Builder
-	line := l.line
-	for {
+// Read an escape sequence, the current char is the one following the backslash
+// Return the bytes of the escaped char
+func (l *Lexer) readEscape() ([]byte, error) {
+	var ch rune
+	pos := l.pos
+	pos.Column-- // position of the backslash
+	switch l.ch {
+	case '0':
+		ch = rune(0)
+	case 'a':
+		ch = '\a'
+	case 'b':
+		ch = '\b'
+	case 'e':
+		ch = 0x1b
+	case 'f':
+		ch = '\f'
+	case 'n':
+		ch = '\n'
+	case 'r':
+		ch = '\r'
+	case 't':
+		ch = '\t'
+	case 'v':
+		ch = '\v'
+	case '\\', '"', '\'':
+		ch = l.ch
+	case 'x': // \xNN
+		escape := "\\x"
+		value := 0
+		for i := 0; i < 2; i++ {
+			if err := l.readRune(); err != nil {
+				return nil, err
+			}
+			if !isDigit(l.ch, 16) {
+				return nil, &InvalidEscape{Escape: escape, Pos: pos}
+			}
+			escape += string(l.ch)
+			digit, _ := strconv.ParseInt(string(l.ch), 16, 0)
+			value = value*16 + int(digit)
+		}
 		if err := l.readRune(); err != nil {
 			return nil, err
 		}
-		if l.ch == rune(0) {
-			return nil, UnmatechedDelimiter
+		return []byte{byte(value)}, nil
+	default:
+		err := &InvalidEscape{Escape: "\\" + string(l.ch), Pos: pos}
+		if l.ch != '\n' {
+			if err := l.readRune(); err != nil {
+				return nil, err
+			}
 		}
-		if l.ch == '"' {
-			break
+		return nil, err
+	}
+	if err := l.readRune(); err != nil {
+		return nil, err
+	}
+	return []byte(string(ch)), nil
+}
+
+// Read a quoted string
+func (l *Lexer) readString() (*Token, error) {
+	var buf bytes.Buffer
+	var escapeErr error
+	pos := l.pos
+	// Consume the opening quote
+	if err := l.readRune(); err != nil {
+		return nil, err
+	}
+	for l.ch != '"' {
+		if l.ch == rune(0) {
+			return nil, &UnmatchedDelimiter{Delimiter: "\"", Pos: pos}
 		}
 		if l.ch == '\\' { // escape
 			if err := l.readRune(); err != nil {
 				return nil, err
 			}
-			switch l.ch {
-			case '\n':
-				continue // escape new line
-			case rune(0):
-				return nil, UnmatechedDelimiter
-			case '0':
-				l.ch = rune(0)
-			case 'n':
-				l.ch = '\n'
-			case 'r':
-				l.ch = '\r'
-			case 't':
-				l.ch = '\t'
+			if l.ch == '\n' { // escape new line
+				if err := l.readRune(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if l.ch == rune(0) {
+				return nil, &UnmatchedDelimiter{Delimiter: "\"", Pos: pos}
+			}
+			value, err := l.readEscape()
+			if err != nil {
+				// Report the first error at the end of the string
+				if escapeErr == nil {
+					escapeErr = err
+				}
+				continue
 			}
+			buf.Write(value)
+			continue
 		}
 		buf.WriteRune(l.ch)
+		if err := l.readRune(); err != nil {
+			return nil, err
+		}
 	}
+	// Consume the closing quote
 	if err := l.readRune(); err != nil {
 		return nil, err
 	}
-	return newToken(STRING, buf.String(), line), nil
+	if escapeErr != nil {
+		return nil, escapeErr
+	}
+	return newToken(STRING, buf.String(), pos), nil
+}
+
+// Read a character literal ('A', '\n'), return a number token with the char value
+func (l *Lexer) readChar() (*Token, error) {
+	var value []byte
+	var err error
+	pos := l.pos
+	// Consume the opening quote
+	if err = l.readRune(); err != nil {
+		return nil, err
+	}
+	switch l.ch {
+	case rune(0), '\n':
+		return nil, &UnmatchedDelimiter{Delimiter: "'", Pos: pos}
+	case '\'':
+		err = &InvalidCharacter{Literal: "''", Pos: pos}
+	case '\\':
+		if err = l.readRune(); err != nil {
+			return nil, err
+		}
+		value, err = l.readEscape()
+	default:
+		value = []byte(string(l.ch))
+		err = l.readRune()
+	}
+	if l.ch != '\'' {
+		// Skip until the closing quote
+		literal := "'" + string(value)
+		for l.ch != '\'' {
+			if l.ch == rune(0) || l.ch == '\n' {
+				return nil, &UnmatchedDelimiter{Delimiter: "'", Pos: pos}
+			}
+			literal += string(l.ch)
+			if err := l.readRune(); err != nil {
+				return nil, err
+			}
+		}
+		if err == nil {
+			err = &InvalidCharacter{Literal: literal + "'", Pos: pos}
+		}
+	}
+	// Consume the closing quote
+	if err := l.readRune(); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	ch := int(value[0])
+	if len(value) > 1 { // UTF-8 char
+		r, _ := utf8.DecodeRune(value)
+		ch = int(r)
+	}
+	return newToken(NUMBER, strconv.Itoa(ch), pos), nil
 }
 
 // Read and Identifier/Label/Instruction
 func (l *Lexer) readIdentifier() (*Token, error) {
 	var buf strings.Builder
-	line := l.line
+	pos := l.pos
 	for isIdentifierChar(l.ch) {
 		buf.WriteRune(l.ch)
 		if err := l.readRune(); err != nil {
 			return nil, err
 		}
 	}
-	token := newToken(IDENTIFIER, strings.ToUpper(buf.String()), line)
+	token := newToken(IDENTIFIER, strings.ToUpper(buf.String()), pos)
 	// Check if the symbol is a label
 	if l.ch == ':' {
 		token.Type = LABEL
@@ -251,7 +431,9 @@ func (l *Lexer) readIdentifier() (*Token, error) {
 	}
 	// Check if the symbol is an instruction
 	_, isInstruction := Instructions[token.Symbol]
-	if isInstruction {
+	_, isFloatInstruction := FloatInstructions[token.Symbol]
+	_, isExtInstruction := ExtInstructions[token.Symbol]
+	if isInstruction || isFloatInstruction || isExtInstruction {
 		token.Type = INSTRUCTION
 	}
 	return token, nil
@@ -118,8 +118,8 @@ label2:
 `)
 
 	tests := []tokenTest{
-		{LABEL, "LABEL1", 1},
-		{LABEL, "LABEL2", 2},
+		{LABEL, "LABEL1", 2},
+		{LABEL, "LABEL2", 3},
 	}
 
 	if err != nil {
@@ -137,9 +137,9 @@ string"
 `)
 
 	tests := []tokenTest{
-		{LABEL, "LABEL1", 1}, {DIRECTIVE, ".ASCIZ", 1}, {STRING, "String", 1},
-		{LABEL, "LABEL2", 2}, {DIRECTIVE, ".ASCIZ", 2}, {STRING, "tab\ttab", 2},
-		{LABEL, "LABEL3", 3}, {DIRECTIVE, ".ASCIZ", 3}, {STRING, "multiline string", 3},
+		{LABEL, "LABEL1", 2}, {DIRECTIVE, ".ASCIZ", 2}, {STRING, "String", 2},
+		{LABEL, "LABEL2", 3}, {DIRECTIVE, ".ASCIZ", 3}, {STRING, "tab\ttab", 3},
+		{LABEL, "LABEL3", 4}, {DIRECTIVE, ".ASCIZ", 4}, {STRING, "multiline string", 4},
 	}
 
 	if err != nil {
@@ -157,10 +157,10 @@ func TestNumbers(t *testing.T) {
 `)
 
 	tests := []tokenTest{
-		{NUMBER, "1", 1}, {NUMBER, "1000", 1}, {INSTRUCTION, "ADD", 1},
-		{NUMBER, "0x1a", 2}, {NUMBER, "-1", 2}, {INSTRUCTION, "MUL", 2},
-		{INSTRUCTION, "PUSH", 3}, {NUMBER, "0xabcd", 3},
-		{INSTRUCTION, "PUSH", 4}, {NUMBER, "000", 4},
+		{NUMBER, "1", 2}, {NUMBER, "1000", 2}, {INSTRUCTION, "ADD", 2},
+		{NUMBER, "0x1a", 3}, {NUMBER, "-1", 3}, {INSTRUCTION, "MUL", 3},
+		{INSTRUCTION, "PUSH", 4}, {NUMBER, "0xabcd", 4},
+		{INSTRUCTION, "PUSH", 5}, {NUMBER, "000", 5},
 	}
 
 	if err != nil {
@@ -181,3 +181,106 @@ func TestIllegalString(t *testing.T) {
 	}
 
 }
+
+func TestColumns(t *testing.T) {
+	lexer, err := runLexer(`push 10
+  label:	add
+`)
+
+	tests := []struct {
+		Symbol string
+		Line   int
+		Column int
+	}{
+		{"PUSH", 1, 1}, {"10", 1, 6},
+		{"LABEL", 2, 3}, {"ADD", 2, 10},
+	}
+
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, test := range tests {
+		token, err := lexer.NextToken()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if token.Symbol != test.Symbol || token.Line != test.Line || token.Column != test.Column {
+			t.Fatalf("expected: %s %d:%d got: %s %d:%d", test.Symbol, test.Line, test.Column, token.Symbol, token.Line, token.Column)
+		}
+	}
+}
+
+func TestNumberFormats(t *testing.T) {
+	lexer, err := runLexer(`
+    0b1010 -0b1 0B11
+    1_000_000 0xff_ff -0x10 0o17
+`)
+
+	tests := []tokenTest{
+		{NUMBER, "0b1010", 2}, {NUMBER, "-0b1", 2}, {NUMBER, "0B11", 2},
+		{NUMBER, "1_000_000", 3}, {NUMBER, "0xff_ff", 3}, {NUMBER, "-0x10", 3}, {NUMBER, "0o17", 3},
+	}
+
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	testLexer(t, lexer, tests)
+}
+
+func TestCharacters(t *testing.T) {
+	lexer, err := runLexer(`
+    'A' ' ' '\n' '\0' '\''
+    '\\' '"' '\x7f' 'è'
+`)
+
+	tests := []tokenTest{
+		{NUMBER, "65", 2}, {NUMBER, "32", 2}, {NUMBER, "10", 2}, {NUMBER, "0", 2}, {NUMBER, "39", 2},
+		{NUMBER, "92", 3}, {NUMBER, "34", 3}, {NUMBER, "127", 3}, {NUMBER, "232", 3},
+	}
+
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	testLexer(t, lexer, tests)
+}
+
+func TestEscapes(t *testing.T) {
+	lexer, err := runLexer(`
+.ascii "a\\b\"c\x41\e\x00"
+`)
+
+	tests := []tokenTest{
+		{DIRECTIVE, ".ASCII", 2}, {STRING, "a\\b\"cA\x1b\x00", 2},
+	}
+
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	testLexer(t, lexer, tests)
+}
+
+func TestIllegalEscapes(t *testing.T) {
+	lexer, err := runLexer(`"\q" "\x4g" 'ab' '' '\z' "ok" 'c`)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := []string{
+		"Invalid escape sequence \\q",
+		"Invalid escape sequence \\x4",
+		"Invalid character literal 'ab'",
+		"Invalid character literal ''",
+		"Invalid escape sequence \\z",
+		"",
+		"Unmatched delimiter '",
+	}
+	for _, message := range expected {
+		_, err := lexer.NextToken()
+		if message == "" {
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+		} else if err == nil || err.Error() != message {
+			t.Fatalf("expected: %s got: %v", message, err)
+		}
+	}
+}
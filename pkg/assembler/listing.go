@@ -0,0 +1,113 @@
+package assembler
+
+import (
+	"fmt"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Max number of bytes in a listing row
+const ListingBytesPerRow = 8
+
+// Bytes emitted by a source line
+type ListingEntry struct {
+	Line  int       // source line
+	Addr  fcpu.Addr // address of the first byte
+	Bytes []byte    // emitted bytes
+}
+
+// Assembler listing, collected during the second pass
+type Listing struct {
+	entries []ListingEntry
+}
+
+// Add the bytes emitted at the given address by a source line
+func (listing *Listing) Add(line int, addr fcpu.Addr, data []byte) {
+	// Merge with the previous entry if the bytes are contiguous
+	if n := len(listing.entries); n > 0 {
+		last := &listing.entries[n-1]
+		if last.Line == line && last.Addr+fcpu.Addr(len(last.Bytes)) == addr {
+			last.Bytes = append(last.Bytes, data...)
+			return
+		}
+	}
+	listing.entries = append(listing.entries, ListingEntry{Line: line, Addr: addr, Bytes: append([]byte{}, data...)})
+}
+
+// Write the listing: source lines with addresses and bytes, symbol table and segment sizes
+func (listing *Listing) Write(w io.Writer, source []string, status *CompilerStatus) {
+	// Group the entries by source line
+	lines := map[int][]ListingEntry{}
+	for _, entry := range listing.entries {
+		lines[entry.Line] = append(lines[entry.Line], entry)
+	}
+	for i, text := range source {
+		line := i + 1
+		text = strings.TrimRight(text, "\r")
+		entries := lines[line]
+		if len(entries) == 0 {
+			fmt.Fprintf(w, "%5d  %8s  %-*s  %s\n", line, "", ListingBytesPerRow*3-1, "", text)
+			continue
+		}
+		first := true
+		for _, entry := range entries {
+			for off := 0; off < len(entry.Bytes); off += ListingBytesPerRow {
+				end := off + ListingBytesPerRow
+				if end > len(entry.Bytes) {
+					end = len(entry.Bytes)
+				}
+				hex := fmt.Sprintf("% x", entry.Bytes[off:end])
+				if first {
+					fmt.Fprintf(w, "%5d  %08x  %-*s  %s\n", line, uint32(entry.Addr)+uint32(off), ListingBytesPerRow*3-1, hex, text)
+					first = false
+				} else {
+					fmt.Fprintf(w, "%5s  %08x  %s\n", "", uint32(entry.Addr)+uint32(off), hex)
+				}
+			}
+		}
+	}
+
+	// Symbol table
+	names := make([]string, 0, len(status.labels))
+	for name := range status.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "\nSymbols by name:\n")
+	for _, name := range names {
+		addr := status.labels[name]
+		fmt.Fprintf(w, "  %-32s %08x  %s\n", name, uint32(addr), status.segmentName(addr))
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return status.labels[names[i]] < status.labels[names[j]]
+	})
+	fmt.Fprintf(w, "\nSymbols by address:\n")
+	for _, name := range names {
+		addr := status.labels[name]
+		fmt.Fprintf(w, "  %08x  %-32s %s\n", uint32(addr), name, status.segmentName(addr))
+	}
+
+	// Segment sizes
+	fmt.Fprintf(w, "\nSegments:\n")
+	fmt.Fprintf(w, "  text  %08x  %8d bytes\n", uint32(status.text.start), status.text.buf.Len())
+	fmt.Fprintf(w, "  data  %08x  %8d bytes\n", uint32(status.data.start), status.data.buf.Len())
+	fmt.Fprintf(w, "  total           %8d bytes\n", status.text.buf.Len()+status.data.buf.Len())
+}
+
+// Write the listing of a source file
+func WriteListing(status *CompilerStatus, filename string, listingFilename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(listingFilename)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	status.listing.Write(output, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), status)
+	return nil
+}
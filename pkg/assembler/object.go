@@ -0,0 +1,236 @@
+package assembler
+
+import (
+	"bufio"
+	"encoding/binary"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"os"
+	"sort"
+)
+
+const ObjectMagic uint32 = 0xc9f70b1e
+
+// Relocatable object header
+type ObjectHeader struct {
+	Magic           uint32
+	TextSize        fcpu.Addr // text size in bytes
+	DataSize        fcpu.Addr // initialized data size in bytes
+	TextBase        fcpu.Addr // base of text used during the assembly
+	DataBase        fcpu.Addr // base of data used during the assembly
+	SymbolCount     uint32    // number of symbols
+	RelocationCount uint32    // number of relocations
+}
+
+// Symbol flags
+const (
+	SymbolGlobal uint8 = 1 << iota // symbol visible to the other objects
+	SymbolExtern                   // symbol defined in another object
+)
+
+// Object symbol
+type Symbol struct {
+	Name  string
+	Value fcpu.Addr // address (assembled with the TextBase/DataBase of the object)
+	Flags uint8
+}
+
+// Segment containing a relocation
+const (
+	SegmentText uint8 = iota
+	SegmentData
+)
+
+// Relocation: the word at Offset in the Segment must be set to the address of the Symbol
+type Relocation struct {
+	Segment uint8
+	Offset  fcpu.Addr
+	Symbol  uint32 // symbol index
+}
+
+// Relocatable object
+type Object struct {
+	Name        string
+	Header      ObjectHeader
+	Text        []byte
+	Data        []byte
+	Symbols     []Symbol
+	Relocations []Relocation
+}
+
+// Relocation collected during the second pass
+type relocation struct {
+	segment *Segment
+	offset  fcpu.Addr
+	symbol  string
+}
+
+// Check if the symbol is global
+func (symbol *Symbol) IsGlobal() bool {
+	return symbol.Flags&SymbolGlobal != 0
+}
+
+// Check if the symbol is extern
+func (symbol *Symbol) IsExtern() bool {
+	return symbol.Flags&SymbolExtern != 0
+}
+
+// Check if the symbol is in the data segment
+func (obj *Object) IsData(symbol *Symbol) bool {
+	return symbol.Value >= obj.Header.DataBase
+}
+
+// Check that the global symbols are defined
+func (status *CompilerStatus) checkGlobals() {
+	for name, pos := range status.globals {
+		if _, exists := status.labels[name]; !exists {
+			status.errors.Add(&UndefinedSymbol{Label: name, Pos: pos})
+		}
+	}
+}
+
+// Create an object from the compiler status
+func NewObject(status *CompilerStatus) *Object {
+	obj := new(Object)
+	obj.Text = status.text.buf.Bytes()
+	obj.Data = status.data.buf.Bytes()
+	// Symbols (labels and externs) sorted by name
+	names := []string{}
+	for name := range status.labels {
+		names = append(names, name)
+	}
+	for name := range status.externs {
+		if _, exists := status.labels[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	index := map[string]uint32{}
+	for i, name := range names {
+		symbol := Symbol{Name: name}
+		if value, exists := status.labels[name]; exists {
+			symbol.Value = value
+			if _, global := status.globals[name]; global {
+				symbol.Flags |= SymbolGlobal
+			}
+		} else {
+			symbol.Flags |= SymbolExtern
+		}
+		index[name] = uint32(i)
+		obj.Symbols = append(obj.Symbols, symbol)
+	}
+	// Relocations
+	for _, r := range status.relocations {
+		segment := SegmentText
+		if r.segment == &status.data {
+			segment = SegmentData
+		}
+		obj.Relocations = append(obj.Relocations, Relocation{Segment: segment, Offset: r.offset, Symbol: index[r.symbol]})
+	}
+	obj.Header = ObjectHeader{
+		Magic:           ObjectMagic,
+		TextSize:        fcpu.Addr(len(obj.Text)),
+		DataSize:        fcpu.Addr(len(obj.Data)),
+		TextBase:        status.text.start,
+		DataBase:        status.data.start,
+		SymbolCount:     uint32(len(obj.Symbols)),
+		RelocationCount: uint32(len(obj.Relocations)),
+	}
+	return obj
+}
+
+// Write the object
+func (obj *Object) Write(w io.Writer) error {
+	if err := binary.Write(w, binary.LittleEndian, obj.Header); err != nil {
+		return err
+	}
+	if _, err := w.Write(obj.Text); err != nil {
+		return err
+	}
+	if _, err := w.Write(obj.Data); err != nil {
+		return err
+	}
+	for _, symbol := range obj.Symbols {
+		fields := []any{symbol.Value, symbol.Flags, uint16(len(symbol.Name)), []byte(symbol.Name)}
+		for _, field := range fields {
+			if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range obj.Relocations {
+		if err := binary.Write(w, binary.LittleEndian, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read an object
+func ReadObject(r io.Reader, name string) (*Object, error) {
+	obj := new(Object)
+	obj.Name = name
+	if err := binary.Read(r, binary.LittleEndian, &obj.Header); err != nil {
+		return nil, err
+	}
+	if obj.Header.Magic != ObjectMagic {
+		return nil, new(fcpu.ExecFormatError)
+	}
+	obj.Text = make([]byte, obj.Header.TextSize)
+	if _, err := io.ReadFull(r, obj.Text); err != nil {
+		return nil, err
+	}
+	obj.Data = make([]byte, obj.Header.DataSize)
+	if _, err := io.ReadFull(r, obj.Data); err != nil {
+		return nil, err
+	}
+	obj.Symbols = make([]Symbol, obj.Header.SymbolCount)
+	for i := range obj.Symbols {
+		var length uint16
+		symbol := &obj.Symbols[i]
+		for _, field := range []any{&symbol.Value, &symbol.Flags, &length} {
+			if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+				return nil, err
+			}
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		symbol.Name = string(name)
+	}
+	obj.Relocations = make([]Relocation, obj.Header.RelocationCount)
+	if err := binary.Read(r, binary.LittleEndian, obj.Relocations); err != nil {
+		return nil, err
+	}
+	for _, r := range obj.Relocations {
+		if r.Symbol >= obj.Header.SymbolCount {
+			return nil, new(fcpu.ExecFormatError)
+		}
+	}
+	return obj, nil
+}
+
+// Write the relocatable object file
+func WriteObject(status *CompilerStatus, outputFilename string) error {
+	output, err := os.Create(outputFilename)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	w := bufio.NewWriter(output)
+	if err = NewObject(status).Write(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Read a relocatable object file
+func ReadObjectFile(filename string) (*Object, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadObject(bufio.NewReader(file), filename)
+}
@@ -1,6 +1,7 @@
 package fcpu
 
 import (
+	"io"
 	"unsafe"
 )
 
@@ -17,9 +18,20 @@ type DeviceDefinition struct {
 	device Device
 }
 
+// Function called before a write of size bytes at address, the write is not executed if the function returns an error
+type WatchHook func(address Addr, size Addr) error
+
+type watch struct {
+	start Addr
+	end   Addr
+	hook  WatchHook
+}
+
 type Bus struct {
 	Mmu     *MMU               // Memory Management Unit
 	Devices []DeviceDefinition // Devices
+	watches []watch            // watched address ranges
+	fault   error              // error raised by a device or by a watch hook
 }
 
 func NewBus() (bus *Bus) {
@@ -31,10 +43,54 @@ func NewBus() (bus *Bus) {
 	return bus
 }
 
+// Release the devices (the clock of the terminal is stopped)
+func (bus *Bus) Close() error {
+	for _, d := range bus.Devices {
+		if closer, ok := d.device.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (bus *Bus) AddDevice(device Device) {
 	bus.Devices = append(bus.Devices, DeviceDefinition{start: device.Start(), end: device.End(), device: device})
 }
 
+// Call the hook before the writes into the addresses from start to end (excluded)
+func (bus *Bus) Watch(start Addr, end Addr, hook WatchHook) {
+	bus.watches = append(bus.watches, watch{start: start, end: end, hook: hook})
+}
+
+// Call the watch hooks of a write, return false if the write is not allowed
+func (bus *Bus) watched(address Addr, size Addr) bool {
+	for _, w := range bus.watches {
+		if address < w.end && address+size > w.start {
+			if err := w.hook(address, size); err != nil {
+				bus.Fault(err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Record an error raised by a device, it stops the instruction being executed
+func (bus *Bus) Fault(err error) {
+	if bus.fault == nil {
+		bus.fault = err
+	}
+}
+
+// Return and clear the error raised by a device
+func (bus *Bus) takeFault() error {
+	err := bus.fault
+	bus.fault = nil
+	return err
+}
+
 // Read a word
 func (bus *Bus) ReadW(address Addr) Word {
 	for _, def := range bus.Devices {
@@ -47,6 +103,14 @@ func (bus *Bus) ReadW(address Addr) Word {
 
 // Write a word into Virtual Memory
 func (bus *Bus) WriteW(address Addr, value Word) {
+	if len(bus.watches) > 0 && !bus.watched(address, WordSize) {
+		return
+	}
+	bus.writeW(address, value)
+}
+
+// Write a word, without calling the watch hooks
+func (bus *Bus) writeW(address Addr, value Word) {
 	for _, def := range bus.Devices {
 		if address >= def.start && address < def.end {
 			def.device.WriteW(address-def.start, value)
@@ -54,6 +118,45 @@ func (bus *Bus) WriteW(address Addr, value Word) {
 	}
 }
 
+// Check if size bytes starting at address are mapped to a device (and to a single memory page)
+func (bus *Bus) Valid(address Addr, size Addr) bool {
+	end := address + size - 1
+	if end < address {
+		return false
+	}
+	for _, def := range bus.Devices {
+		if address >= def.start && end < def.end {
+			if def.device == Device(bus.Mmu) {
+				return bus.Mmu.GetPageNumber(address) == bus.Mmu.GetPageNumber(end)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Check if size bytes starting at address are mapped to devices (the bytes can span multiple memory pages)
+func (bus *Bus) ValidRange(address Addr, size Addr) bool {
+	if size == 0 {
+		return true
+	}
+	if address+size-1 < address {
+		return false
+	}
+	for size > 0 {
+		n := VirtualPageSize - address%VirtualPageSize // bytes up to the end of the page
+		if n > size {
+			n = size
+		}
+		if !bus.Valid(address, n) {
+			return false
+		}
+		address += n
+		size -= n
+	}
+	return true
+}
+
 // Read a byte
 func (bus *Bus) ReadB(address Addr) byte {
 	// Calculate the offset
@@ -67,11 +170,14 @@ func (bus *Bus) ReadB(address Addr) byte {
 func (bus *Bus) WriteB(address Addr, value byte) {
 	// Calculate the offset
 	off := address & Addr(MemMask)
+	if len(bus.watches) > 0 && !bus.watched(address, 1) {
+		return
+	}
 	// Read the word
 	wordValue := bus.ReadW(address - off)
 	// Updatew the word
 	(*[4]byte)(unsafe.Pointer(&wordValue))[off] = value
-	bus.WriteW(address-off, wordValue)
+	bus.writeW(address-off, wordValue)
 }
 
 // Write multiple words
@@ -87,3 +193,17 @@ func (bus *Bus) WriteBytes(address Addr, value []byte) {
 		bus.WriteB(address+Addr(i), v)
 	}
 }
+
+// Read multiple bytes
+func (bus *Bus) ReadBytes(address Addr, size Addr) []byte {
+	result := make([]byte, size)
+	for i := range result {
+		result[i] = bus.ReadB(address + Addr(i))
+	}
+	return result
+}
+
+// Copy size bytes from src to dst (the blocks can overlap)
+func (bus *Bus) Move(src Addr, dst Addr, size Addr) {
+	bus.WriteBytes(dst, bus.ReadBytes(src, size))
+}
@@ -3,6 +3,7 @@ package fcpu
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"time"
 	"unsafe"
@@ -10,6 +11,7 @@ import (
 
 const DataStackTop = 1 << 16
 const ReturnStackTop = 1 << 15
+const FloatStackTop = 1 << 14
 
 type Addr uint32
 type Word int32
@@ -27,6 +29,9 @@ const MemMask = int(WordSize - 1)
 // CLOCK   = 0o100
 // RK      = 0o220
 
+// Address of the cell containing the address of the trap handler (0 if the traps are not handled)
+const TrapVector Addr = 0o010
+
 const BinaryMagic uint32 = 0xc9f7a115
 const MemoryLimit Addr = 0xfffffc00
 
@@ -43,15 +48,48 @@ type CPU struct {
 	pc      Addr   // Program counter
 	Ds      *Stack // Data Stack
 	Rs      *Stack // Return Stack
+	Fs      *Stack // Floating-point Stack
+	Heap    *Heap  // Heap device
 	Verbose bool
 	Time    uint64
 	Limit   uint64
+	Output  io.Writer // Output of EMIT
+	Input   io.Reader // Input of KEY
 }
 
+// Create a CPU with an empty memory
+func New() *CPU {
+	cpu := new(CPU)
+	cpu.bus = NewBus()
+	cpu.Output = os.Stdout
+	cpu.Input = os.Stdin
+	cpu.Ds = NewStack(cpu.bus, DataStackTop)
+	cpu.Rs = NewStack(cpu.bus, ReturnStackTop)
+	cpu.Fs = NewStack(cpu.bus, FloatStackTop)
+	cpu.Heap = NewHeap(cpu.bus)
+	cpu.bus.AddDevice(cpu.Heap)
+	return cpu
+}
+
+// Create a CPU and load a binary file
 func NewCPU(filename string) (*CPU, error) {
+	cpu := New()
+	if err := cpu.Load(filename); err != nil {
+		return nil, err
+	}
+	return cpu, nil
+}
+
+// Stop the devices of the CPU (the clock of the terminal), the memory and the stacks can still be read
+func (cpu *CPU) Close() error {
+	return cpu.bus.Close()
+}
+
+// Load a binary file into the memory, the program counter is set to the base of text
+func (cpu *CPU) Load(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 
@@ -59,24 +97,18 @@ func NewCPU(filename string) (*CPU, error) {
 	var header BinaryHeader
 	err = binary.Read(file, binary.LittleEndian, &header)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if header.Magic != BinaryMagic {
-		return nil, new(ExecFormatError)
+		return new(ExecFormatError)
 	}
-
-	var cpu *CPU
-	cpu = new(CPU)
-	cpu.bus = NewBus()
 	cpu.pc = header.TextBase
-	cpu.Ds = NewStack(cpu.bus, DataStackTop)
-	cpu.Rs = NewStack(cpu.bus, ReturnStackTop)
 
 	// Load text segment
 	var text = make([]byte, header.TextSize)
 	_, err = file.Read(text)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	cpu.bus.WriteBytes(header.TextBase, text)
 
@@ -85,11 +117,36 @@ func NewCPU(filename string) (*CPU, error) {
 		var data = make([]byte, header.DataSize)
 		_, err = file.Read(data)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		cpu.bus.WriteBytes(header.DataBase, data)
 	}
-	return cpu, nil
+	return nil
+}
+
+// Set the program counter
+func (cpu *CPU) Jump(address Addr) {
+	cpu.pc = address
+}
+
+// Read a word from the memory
+func (cpu *CPU) ReadWord(address Addr) Word {
+	return cpu.bus.ReadW(address)
+}
+
+// Write a word into the memory
+func (cpu *CPU) WriteWord(address Addr, value Word) {
+	cpu.bus.WriteW(address, value)
+}
+
+// Write bytes into the memory, starting at address
+func (cpu *CPU) WriteBytes(address Addr, value []byte) {
+	cpu.bus.WriteBytes(address, value)
+}
+
+// Read size bytes from the memory, starting at address
+func (cpu *CPU) ReadBytes(address Addr, size Addr) []byte {
+	return cpu.bus.ReadBytes(address, size)
 }
 
 func (cpu *CPU) PrintRegisters() {
@@ -158,9 +215,9 @@ func (cpu *CPU) Eval() error {
 		cpu.Ds.Push(Word(cpu.bus.ReadB(cpu.pc)))
 		cpu.pc += 1
 	case EMIT: // TODO
-		fmt.Printf("%c", int(v1))
+		fmt.Fprintf(cpu.Output, "%c", int(v1))
 	case PERIOD: // TODO
-		fmt.Printf(">>>> %d\n", int(v1))
+		fmt.Fprintf(cpu.Output, ">>>> %d\n", int(v1))
 	case DROP: /* Discards the top stack item */
 		break
 	case DUP: /* Duplicates the top stack item */
@@ -194,6 +251,9 @@ func (cpu *CPU) Eval() error {
 	case MUL:
 		cpu.Ds.Push(v1 * v2)
 	case DIV:
+		if v2 == 0 {
+			return cpu.trap(DivisionByZero)
+		}
 		cpu.Ds.Push(v1 / v2)
 	case MAX:
 		if v1 > v2 {
@@ -214,11 +274,26 @@ func (cpu *CPU) Eval() error {
 			cpu.Ds.Push(v1)
 		}
 	case MOD:
+		if v2 == 0 {
+			return cpu.trap(DivisionByZero)
+		}
 		cpu.Ds.Push(v1 % v2)
 	case LSHIFT:
 		cpu.Ds.Push(v1 << v2)
 	case RSHIFT:
-		cpu.Ds.Push(v1 >> v2)
+		cpu.Ds.Push(Word(uint32(v1) >> uint32(v2)))
+	case ARSHIFT:
+		cpu.Ds.Push(v1 >> uint32(v2))
+	case UDIV:
+		if v2 == 0 {
+			return cpu.trap(DivisionByZero)
+		}
+		cpu.Ds.Push(Word(uint32(v1) / uint32(v2)))
+	case UMOD:
+		if v2 == 0 {
+			return cpu.trap(DivisionByZero)
+		}
+		cpu.Ds.Push(Word(uint32(v1) % uint32(v2)))
 	case AND:
 		cpu.Ds.Push(v1 & v2)
 	case OR:
@@ -239,15 +314,31 @@ func (cpu *CPU) Eval() error {
 		cpu.Ds.PushBool(v1 <= v2)
 	case LT: /* Compare for Less */
 		cpu.Ds.PushBool(v1 < v2)
+	case UGT: /* Unsigned compare for Greater */
+		cpu.Ds.PushBool(uint32(v1) > uint32(v2))
+	case ULT: /* Unsigned compare for Less */
+		cpu.Ds.PushBool(uint32(v1) < uint32(v2))
 	case STORE:
+		if !cpu.bus.Valid(Addr(v2), WordSize) {
+			return cpu.trap(InvalidAddress)
+		}
 		cpu.bus.WriteW(Addr(v2), v1)
 	case STORE_B:
+		if !cpu.bus.Valid(Addr(v2), 1) {
+			return cpu.trap(InvalidAddress)
+		}
 		cpu.bus.WriteB(Addr(v2), byte(v1))
 	case FETCH:
+		if !cpu.bus.Valid(Addr(v1), WordSize) {
+			return cpu.trap(InvalidAddress)
+		}
 		value := cpu.bus.ReadW(Addr(v1))
 		// fmt.Println("FETCH: ---", int(v1), int(value))
 		cpu.Ds.Push(value)
 	case FETCH_B:
+		if !cpu.bus.Valid(Addr(v1), 1) {
+			return cpu.trap(InvalidAddress)
+		}
 		value := Word(cpu.bus.ReadB(Addr(v1)))
 		// fmt.Println("FETCH_B: ---", int(v1), int(value))
 		cpu.Ds.Push(value)
@@ -273,6 +364,43 @@ func (cpu *CPU) Eval() error {
 		cpu.Rs.origin = Addr(v1)
 	case PUSHPC:
 		cpu.Ds.Push(Word(cpu.pc))
+	case KEY:
+		var ch [1]byte
+		if _, err := io.ReadFull(cpu.Input, ch[:]); err != nil {
+			cpu.Ds.Push(-1) // end of the input
+		} else {
+			cpu.Ds.Push(Word(ch[0]))
+		}
+	case ADDC:
+		cpu.pushDouble(uint64(uint32(v1)) + uint64(uint32(v2)))
+	case UMUL:
+		cpu.pushDouble(uint64(uint32(v1)) * uint64(uint32(v2)))
+	case MMUL:
+		cpu.pushDouble(uint64(int64(v1) * int64(v2)))
+	case UMDIV:
+		lo, _ := cpu.Ds.Pop()
+		if v2 == 0 {
+			return cpu.trap(DivisionByZero)
+		}
+		dividend, divisor := uint64(uint32(lo))|uint64(uint32(v1))<<32, uint64(uint32(v2))
+		cpu.Ds.Push(Word(dividend % divisor))
+		cpu.Ds.Push(Word(dividend / divisor))
+	case SMDIV:
+		lo, _ := cpu.Ds.Pop()
+		if v2 == 0 {
+			return cpu.trap(DivisionByZero)
+		}
+		dividend, divisor := int64(uint64(uint32(lo))|uint64(uint32(v1))<<32), int64(v2)
+		cpu.Ds.Push(Word(dividend % divisor))
+		cpu.Ds.Push(Word(dividend / divisor))
+	case FPU:
+		if err := cpu.fpu(); err != nil {
+			return err
+		}
+	case EXT:
+		if err := cpu.ext(); err != nil {
+			return err
+		}
 	case CALL:
 		cpu.Rs.Push(Word(cpu.pc))
 		// cpu.bus.WriteW(cpu.rsp, Word(cpu.rsp))            // store rsp
@@ -288,6 +416,26 @@ func (cpu *CPU) Eval() error {
 		v1, _ = cpu.Rs.Pop()
 		cpu.pc = Addr(v1)
 	}
+	return cpu.bus.takeFault()
+}
+
+// Push a double-cell number (the low cell first)
+func (cpu *CPU) pushDouble(value uint64) {
+	cpu.Ds.Push(Word(uint32(value)))
+	cpu.Ds.Push(Word(uint32(value >> 32)))
+}
+
+// Raise a trap. If the trap vector contains the address of a handler, the handler
+// is called (like CALL) with the trap code on the data stack, otherwise the
+// execution stops with a Trap error.
+func (cpu *CPU) trap(code TrapCode) error {
+	handler := Addr(cpu.bus.ReadW(TrapVector))
+	if handler == 0 {
+		return &Trap{Code: code, Addr: cpu.pc - OpSize}
+	}
+	cpu.Ds.Push(Word(code))
+	cpu.Rs.Push(Word(cpu.pc))
+	cpu.pc = handler
 	return nil
 }
 
@@ -0,0 +1,164 @@
+package fcpu
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Run a program loaded at address 0x100, until HLT or an error
+func runProgram(cpu *CPU, program []byte) error {
+	cpu.WriteBytes(0x100, program)
+	cpu.Jump(0x100)
+	for {
+		if err := cpu.Eval(); err != nil {
+			if _, halt := err.(*Halt); halt {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestTrap(t *testing.T) {
+	divide := []byte{PUSH_B, 5, PUSH_B, 0, DIV, byte(HLT)}
+	fetch := []byte{PUSH_B, 0, PUSH_B, 1, SUB, FETCH_B, byte(HLT)} // fetch a byte from 0xffffffff
+
+	// No handler: the execution stops
+	var trap *Trap
+	if err := runProgram(New(), divide); !errors.As(err, &trap) || trap.Code != DivisionByZero || trap.Addr != 0x104 {
+		t.Errorf("divide: expected division by zero at 00000104, got %v", err)
+	}
+	if err := runProgram(New(), fetch); !errors.As(err, &trap) || trap.Code != InvalidAddress {
+		t.Errorf("fetch: expected invalid address, got %v", err)
+	}
+
+	// The handler is called with the trap code on the data stack
+	cpu := New()
+	cpu.WriteBytes(0x200, []byte{byte(HLT)})
+	cpu.WriteWord(TrapVector, 0x200)
+	if err := runProgram(cpu, divide); err != nil {
+		t.Fatal(err)
+	}
+	if stack := cpu.Ds.Array(); !reflect.DeepEqual(stack, []Word{Word(DivisionByZero)}) {
+		t.Errorf("Expected [%d], got %v", DivisionByZero, stack)
+	}
+	if stack := cpu.Rs.Array(); !reflect.DeepEqual(stack, []Word{0x105}) {
+		t.Errorf("Expected return address 0x105, got %v", stack)
+	}
+}
+
+func TestValid(t *testing.T) {
+	bus := NewBus()
+	for _, test := range []struct {
+		address Addr
+		size    Addr
+		valid   bool
+	}{
+		{0, WordSize, true},
+		{VirtualPageSize - WordSize, WordSize, true},
+		{VirtualPageSize - 1, WordSize, false}, // crossing a page
+		{MemoryLimit, WordSize, true},          // terminal
+		{MemoryLimit + 0x100, 1, false},
+		{0xffffffff, WordSize, false},
+	} {
+		if valid := bus.Valid(test.address, test.size); valid != test.valid {
+			t.Errorf("%08x (%d bytes): expected %v", test.address, test.size, test.valid)
+		}
+	}
+}
+
+func TestDoubleOps(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		program  []byte
+		expected []Word
+	}{
+		{"addc", []byte{PUSH_B, 0, PUSH_B, 1, SUB, PUSH_B, 2, ADDC}, []Word{1, 1}},
+		{"umul", []byte{PUSH_B, 0, PUSH_B, 1, SUB, PUSH_B, 2, UMUL}, []Word{-2, 1}},
+		{"mmul", []byte{PUSH_B, 0, PUSH_B, 1, SUB, PUSH_B, 2, MMUL}, []Word{-2, -1}},
+		{"umdiv", []byte{PUSH_B, 7, PUSH_B, 1, PUSH_B, 4, UMDIV}, []Word{3, 0x40000001}},
+		{"smdiv", []byte{PUSH_B, 0, PUSH_B, 7, SUB, PUSH_B, 0, PUSH_B, 1, SUB, PUSH_B, 2, SMDIV}, []Word{-1, -3}},
+	} {
+		cpu := New()
+		if err := runProgram(cpu, append(test.program, byte(HLT))); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if stack := cpu.Ds.Array(); !reflect.DeepEqual(stack, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, stack)
+		}
+	}
+}
+
+func TestFpu(t *testing.T) {
+	cpu := New()
+	cpu.WriteWord(0x300, Word(math.Float32bits(1.5)))
+	program := []byte{
+		PUSH_B, 0, PUSH_B, 3, PUSH_B, 8, LSHIFT, ADD, FPU, byte(FLOAD), // 1.5
+		FPU, byte(FDUP), FPU, byte(FADD), FPU, byte(FDUP), FPU, byte(FMUL), // 9
+		PUSH_B, 0x40, PUSH_B, 3, PUSH_B, 8, LSHIFT, ADD, FPU, byte(FSTORE),
+		PUSH_B, 0x40, PUSH_B, 3, PUSH_B, 8, LSHIFT, ADD, FETCH,
+		byte(HLT),
+	}
+	if err := runProgram(cpu, program); err != nil {
+		t.Fatal(err)
+	}
+	if stack := cpu.Ds.Array(); !reflect.DeepEqual(stack, []Word{Word(math.Float32bits(9))}) {
+		t.Errorf("Expected 9.0, got %v", stack)
+	}
+	if size := cpu.Fs.Size(); size != 0 {
+		t.Errorf("Expected an empty floating-point stack, got %d items", size)
+	}
+}
+
+func TestValidRange(t *testing.T) {
+	bus := NewBus()
+	for _, test := range []struct {
+		address Addr
+		size    Addr
+		valid   bool
+	}{
+		{0, 0, true},
+		{0, 3 * VirtualPageSize, true}, // spanning pages
+		{VirtualPageSize - 1, 2, true},
+		{0xffffffff, 2, false}, // wrapping around
+	} {
+		if valid := bus.ValidRange(test.address, test.size); valid != test.valid {
+			t.Errorf("ValidRange(%x, %d): expected %v, got %v", test.address, test.size, test.valid, valid)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	cpu := New()
+	cpu.WriteBytes(0x300, []byte("abcdef"))
+	program := []byte{
+		PUSH_B, 0, PUSH_B, 3, PUSH_B, 8, LSHIFT, ADD, // 0x300
+		DUP, PUSH_B, 2, ADD, PUSH_B, 4, EXT, byte(BMOVE), // abcdef => ababcd
+		byte(HLT),
+	}
+	if err := runProgram(cpu, program); err != nil {
+		t.Fatal(err)
+	}
+	if text := string(cpu.ReadBytes(0x300, 6)); text != "ababcd" {
+		t.Errorf("Expected ababcd, got %s", text)
+	}
+}
+
+func TestClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	cpu := New()
+	if err := cpu.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The clock of the terminal stops
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines expected after Close, got %d", goroutines, n)
+	}
+}
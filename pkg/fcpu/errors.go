@@ -1,5 +1,9 @@
 package fcpu
 
+import (
+	"fmt"
+)
+
 type Halt struct {
 }
 
@@ -7,6 +11,44 @@ func (e *Halt) Error() string {
 	return "Halt"
 }
 
+type TrapCode Word
+
+// Traps, raised by the instructions that can not be executed
+const (
+	DivisionByZero TrapCode = 1 // division (or modulo) by zero
+	InvalidAddress TrapCode = 2 // access to an address not mapped to a device, or crossing a memory page
+)
+
+func (code TrapCode) String() string {
+	switch code {
+	case DivisionByZero:
+		return "Division by zero"
+	case InvalidAddress:
+		return "Invalid address"
+	}
+	return fmt.Sprintf("Trap %d", Word(code))
+}
+
+// Trap not handled by the program
+type Trap struct {
+	Code TrapCode
+	Addr Addr // address of the instruction raising the trap
+}
+
+func (e *Trap) Error() string {
+	return fmt.Sprintf("%s at %08x", e.Code, e.Addr)
+}
+
+// Heap error, detected in debug mode
+type HeapError struct {
+	Message string
+	Addr    Addr // address of the block or of the write
+}
+
+func (e *HeapError) Error() string {
+	return fmt.Sprintf("Heap error: %s at %08x", e.Message, e.Addr)
+}
+
 type ExecFormatError struct {
 }
 
@@ -0,0 +1,72 @@
+package fcpu
+
+// Extended instructions
+//
+// The extended instructions are encoded as the EXT opcode followed by the
+// extended operation. The block instructions operate directly on the memory,
+// a range not mapped to a device raises the invalid address trap. The data
+// stack registers are saved and restored to switch between tasks with their
+// own data stack.
+
+type XOp byte
+
+const (
+	/* Memory blocks */
+	BMOVE     XOp = iota /* Copy u bytes from addr1 to addr2, as if through a temporary buffer ( addr1 addr2 u -- ) */
+	BMOVEUP              /* Copy u bytes from addr1 to addr2, from lower to higher addresses ( addr1 addr2 u -- ) */
+	BMOVEDOWN            /* Copy u bytes from addr1 to addr2, from higher to lower addresses ( addr1 addr2 u -- ) */
+	BFILL                /* Store char in u consecutive bytes starting at addr ( addr u char -- ) */
+
+	/* Data stack registers (used to switch tasks) */
+	PUSHSP /* Push the data stack pointer (before the push) */
+	POPSP  /* Pop -> data stack pointer */
+	PUSHSB /* Push the data stack base */
+	POPSB  /* Pop -> data stack base */
+)
+
+// Execute an extended instruction
+func (cpu *CPU) ext() error {
+	op := XOp(cpu.bus.ReadB(cpu.pc))
+	cpu.pc += OpSize
+
+	switch op {
+	case BMOVE, BMOVEUP, BMOVEDOWN:
+		size, _ := cpu.Ds.Pop()
+		src, dst, _ := cpu.Ds.Pop2()
+		if !cpu.bus.ValidRange(Addr(src), Addr(size)) || !cpu.bus.ValidRange(Addr(dst), Addr(size)) {
+			return cpu.trap(InvalidAddress)
+		}
+		switch op {
+		case BMOVE:
+			cpu.bus.Move(Addr(src), Addr(dst), Addr(size))
+		case BMOVEUP:
+			for i := Addr(0); i < Addr(size); i++ {
+				cpu.bus.WriteB(Addr(dst)+i, cpu.bus.ReadB(Addr(src)+i))
+			}
+		case BMOVEDOWN:
+			for i := Addr(size); i > 0; i-- {
+				cpu.bus.WriteB(Addr(dst)+i-1, cpu.bus.ReadB(Addr(src)+i-1))
+			}
+		}
+	case BFILL:
+		char, _ := cpu.Ds.Pop()
+		addr, size, _ := cpu.Ds.Pop2()
+		if !cpu.bus.ValidRange(Addr(addr), Addr(size)) {
+			return cpu.trap(InvalidAddress)
+		}
+		for i := Addr(0); i < Addr(size); i++ {
+			cpu.bus.WriteB(Addr(addr)+i, byte(char))
+		}
+	case PUSHSP:
+		cpu.Ds.Push(Word(cpu.Ds.pointer))
+	case POPSP:
+		v1, _ := cpu.Ds.Pop()
+		cpu.Ds.pointer = Addr(v1)
+	case PUSHSB:
+		cpu.Ds.Push(Word(cpu.Ds.origin))
+	case POPSB:
+		v1, _ := cpu.Ds.Pop()
+		cpu.Ds.origin = Addr(v1)
+	}
+	return nil
+}
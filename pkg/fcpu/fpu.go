@@ -0,0 +1,192 @@
+package fcpu
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Floating-point unit
+//
+// The floating-point instructions are encoded as the FPU opcode followed by
+// the floating-point operation. The floating-point numbers (IEEE 754 binary32)
+// are kept on the floating-point stack, while the addresses, the integers and
+// the flags are on the data stack.
+
+type FOp byte
+
+const (
+	/* Memory and transfer */
+	FLOAD  FOp = iota /* Fetch a float ( a-addr -- ) ( F: -- r ) */
+	FSTORE            /* Store a float ( a-addr -- ) ( F: r -- ) */
+	FPUSH             /* Move the bits of a cell to the floating-point stack ( x -- ) ( F: -- r ) */
+	FPOP              /* Move the bits of a float to the data stack ( -- x ) ( F: r -- ) */
+
+	/* Stack manipulation */
+	FDUP   /* Duplicates the top stack item */
+	FDROP  /* Discards the top stack item */
+	FSWAP  /* Reverses the top two stack items */
+	FOVER  /* Make copy of second item on top */
+	FDEPTH /* Count number of items on the floating-point stack ( -- n ) */
+
+	/* Arithmetic */
+	FADD /* Add */
+	FSUB /* Subtract */
+	FMUL /* Multiply */
+	FDIV /* Divide */
+	FNEG /* Negate */
+	FABS /* Absolute value */
+	FMAX /* Leave greater of two numbers */
+	FMIN /* Leave lesser of two numbers */
+
+	/* Comparison (the flag is pushed on the data stack) */
+	FEQ /* Compare Equal */
+	FLT /* Compare for Less */
+
+	/* Conversion */
+	ITOF   /* Convert a number ( n -- ) ( F: -- r ) */
+	FTOI   /* Convert to a number, rounding toward zero ( -- n ) ( F: r -- ) */
+	DTOF   /* Convert a double-cell number ( d -- ) ( F: -- r ) */
+	FTOD   /* Convert to a double-cell number, rounding toward zero ( -- d ) ( F: r -- ) */
+	FFLOOR /* Round toward negative infinity */
+	FROUND /* Round to the nearest integer, ties to even */
+	FTRUNC /* Round toward zero */
+
+	/* Functions */
+	FSQRT  /* Square root */
+	FSIN   /* Sine */
+	FCOS   /* Cosine */
+	FTAN   /* Tangent */
+	FATAN2 /* Arc tangent of r1/r2 ( F: r1 r2 -- r3 ) */
+	FEXP   /* Base-e exponential */
+	FLN    /* Natural logarithm */
+	FPOW   /* Raise r1 to the power r2 ( F: r1 r2 -- r3 ) */
+
+	/* Output */
+	FPRINT /* Display a float in fixed-point notation ( F: r -- ) */
+)
+
+// Pop a float from the floating-point stack
+func (cpu *CPU) popFloat() float64 {
+	value, _ := cpu.Fs.Pop()
+	return float64(math.Float32frombits(uint32(value)))
+}
+
+// Pop two floats from the floating-point stack, r2 is the top item
+func (cpu *CPU) popFloat2() (r1 float64, r2 float64) {
+	r2 = cpu.popFloat()
+	r1 = cpu.popFloat()
+	return r1, r2
+}
+
+// Push a float on the floating-point stack, rounding it to binary32
+func (cpu *CPU) pushFloat(r float64) {
+	cpu.Fs.Push(Word(math.Float32bits(float32(r))))
+}
+
+// Execute a floating-point instruction
+func (cpu *CPU) fpu() error {
+	op := FOp(cpu.bus.ReadB(cpu.pc))
+	cpu.pc += OpSize
+
+	switch op {
+	case FLOAD:
+		addr, _ := cpu.Ds.Pop()
+		if !cpu.bus.Valid(Addr(addr), WordSize) {
+			return cpu.trap(InvalidAddress)
+		}
+		cpu.Fs.Push(cpu.bus.ReadW(Addr(addr)))
+	case FSTORE:
+		addr, _ := cpu.Ds.Pop()
+		if !cpu.bus.Valid(Addr(addr), WordSize) {
+			return cpu.trap(InvalidAddress)
+		}
+		value, _ := cpu.Fs.Pop()
+		cpu.bus.WriteW(Addr(addr), value)
+	case FPUSH:
+		value, _ := cpu.Ds.Pop()
+		cpu.Fs.Push(value)
+	case FPOP:
+		value, _ := cpu.Fs.Pop()
+		cpu.Ds.Push(value)
+	case FDUP:
+		cpu.Fs.Dup()
+	case FDROP:
+		cpu.Fs.Pop()
+	case FSWAP:
+		r1, r2, _ := cpu.Fs.Pop2()
+		cpu.Fs.Push(r2)
+		cpu.Fs.Push(r1)
+	case FOVER:
+		cpu.Fs.Pick(1)
+	case FDEPTH:
+		cpu.Ds.Push(Word(cpu.Fs.Size()))
+	case FADD:
+		r1, r2 := cpu.popFloat2()
+		cpu.pushFloat(r1 + r2)
+	case FSUB:
+		r1, r2 := cpu.popFloat2()
+		cpu.pushFloat(r1 - r2)
+	case FMUL:
+		r1, r2 := cpu.popFloat2()
+		cpu.pushFloat(r1 * r2)
+	case FDIV:
+		r1, r2 := cpu.popFloat2()
+		cpu.pushFloat(r1 / r2)
+	case FNEG:
+		cpu.pushFloat(-cpu.popFloat())
+	case FABS:
+		cpu.pushFloat(math.Abs(cpu.popFloat()))
+	case FMAX:
+		cpu.pushFloat(math.Max(cpu.popFloat2()))
+	case FMIN:
+		cpu.pushFloat(math.Min(cpu.popFloat2()))
+	case FEQ: /* Compare Equal */
+		r1, r2 := cpu.popFloat2()
+		cpu.Ds.PushBool(r1 == r2)
+	case FLT: /* Compare for Less */
+		r1, r2 := cpu.popFloat2()
+		cpu.Ds.PushBool(r1 < r2)
+	case ITOF:
+		n, _ := cpu.Ds.Pop()
+		cpu.pushFloat(float64(n))
+	case FTOI:
+		cpu.Ds.Push(Word(cpu.popFloat()))
+	case DTOF:
+		hi, _ := cpu.Ds.Pop()
+		lo, _ := cpu.Ds.Pop()
+		cpu.pushFloat(float64(int64(uint64(uint32(lo)) | uint64(uint32(hi))<<32)))
+	case FTOD:
+		cpu.pushDouble(uint64(int64(cpu.popFloat())))
+	case FFLOOR:
+		cpu.pushFloat(math.Floor(cpu.popFloat()))
+	case FROUND:
+		cpu.pushFloat(math.RoundToEven(cpu.popFloat()))
+	case FTRUNC:
+		cpu.pushFloat(math.Trunc(cpu.popFloat()))
+	case FSQRT:
+		cpu.pushFloat(math.Sqrt(cpu.popFloat()))
+	case FSIN:
+		cpu.pushFloat(math.Sin(cpu.popFloat()))
+	case FCOS:
+		cpu.pushFloat(math.Cos(cpu.popFloat()))
+	case FTAN:
+		cpu.pushFloat(math.Tan(cpu.popFloat()))
+	case FATAN2:
+		cpu.pushFloat(math.Atan2(cpu.popFloat2()))
+	case FEXP:
+		cpu.pushFloat(math.Exp(cpu.popFloat()))
+	case FLN:
+		cpu.pushFloat(math.Log(cpu.popFloat()))
+	case FPOW:
+		cpu.pushFloat(math.Pow(cpu.popFloat2()))
+	case FPRINT:
+		text := strconv.FormatFloat(cpu.popFloat(), 'f', -1, 32)
+		if !strings.ContainsAny(text, ".IN") { // 3 is displayed as "3."
+			text += "."
+		}
+		fmt.Fprint(cpu.Output, text)
+	}
+	return nil
+}
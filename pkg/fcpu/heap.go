@@ -0,0 +1,235 @@
+package fcpu
+
+import (
+	"sort"
+)
+
+// Heap
+//
+// The heap is a host-assisted device: the allocated blocks are in the memory
+// (from HeapStart to HeapEnd), while the device keeps the list of the blocks.
+// The program writes the operands into the address and size registers, then
+// writes the command into the command register. The results are available in
+// the address and ior registers.
+//
+// In debug mode the device watches the writes into the heap memory: a write
+// outside the allocated blocks (past the end of a block or into a freed block),
+// a double free and the free of an address not allocated stop the execution
+// with a HeapError. The blocks are separated by a guard cell, so an overflow
+// does not reach the next block.
+
+const HeapStart Addr = 0x10000000
+const HeapEnd Addr = 0x11000000
+
+// Address of the heap registers
+const HeapRegisters Addr = MemoryLimit + 0x10
+
+// Heap registers (offsets from HeapRegisters)
+const (
+	HeapAddress Addr = 0  // address of the block (operand and result)
+	HeapSize    Addr = 4  // size of the block (operand)
+	HeapCommand Addr = 8  // command, executed when written
+	HeapIor     Addr = 12 // I/O result of the last command (0 on success)
+)
+
+// Heap commands
+const (
+	HeapAllocate Word = 1 // allocate a block of size bytes
+	HeapFree     Word = 2 // free the block at address
+	HeapResize   Word = 3 // resize the block at address to size bytes (the block can be moved)
+)
+
+// I/O results of the heap commands (the THROW codes of ALLOCATE, FREE and RESIZE)
+const (
+	AllocateError Word = -59
+	FreeError     Word = -60
+	ResizeError   Word = -61
+)
+
+type heapBlock struct {
+	addr Addr
+	size Addr
+}
+
+// End of the memory used by the block
+func (block heapBlock) end() Addr {
+	return block.addr + roundUp(block.size)
+}
+
+type Heap struct {
+	bus     *Bus
+	blocks  []heapBlock   // allocated blocks, sorted by address
+	debug   bool          // debug mode
+	freed   map[Addr]bool // addresses of the freed blocks (in debug mode)
+	address Word          // address register
+	size    Word          // size register
+	ior     Word          // ior register
+}
+
+func NewHeap(bus *Bus) (heap *Heap) {
+	heap = new(Heap)
+	heap.bus = bus
+	heap.freed = map[Addr]bool{}
+	return heap
+}
+
+// Enable the debug mode, watching the writes into the heap memory
+func (heap *Heap) Debug() {
+	if !heap.debug {
+		heap.debug = true
+		heap.bus.Watch(HeapStart, HeapEnd, heap.checkWrite)
+	}
+}
+
+func (heap *Heap) Start() Addr {
+	return HeapRegisters
+}
+
+func (heap *Heap) End() Addr {
+	return HeapRegisters + 4*WordSize
+}
+
+func (heap *Heap) ReadW(address Addr) Word {
+	switch address {
+	case HeapAddress:
+		return heap.address
+	case HeapSize:
+		return heap.size
+	case HeapIor:
+		return heap.ior
+	}
+	return 0
+}
+
+func (heap *Heap) WriteW(address Addr, value Word) {
+	switch address {
+	case HeapAddress:
+		heap.address = value
+	case HeapSize:
+		heap.size = value
+	case HeapCommand:
+		heap.execute(value)
+	}
+}
+
+// Round up a size to the cell size, the size of a block is at least one cell
+// (the sizes larger than the heap are not rounded, so they can not overflow)
+func roundUp(size Addr) Addr {
+	if size == 0 {
+		size = 1
+	}
+	if size > HeapEnd-HeapStart {
+		return size
+	}
+	return (size + WordSize - 1) &^ (WordSize - 1)
+}
+
+// Size of the guard between the blocks
+func (heap *Heap) guard() Addr {
+	if heap.debug {
+		return WordSize
+	}
+	return 0
+}
+
+// Return the index of the block at address, -1 if not found
+func (heap *Heap) find(address Addr) int {
+	i := sort.Search(len(heap.blocks), func(i int) bool { return heap.blocks[i].addr >= address })
+	if i < len(heap.blocks) && heap.blocks[i].addr == address {
+		return i
+	}
+	return -1
+}
+
+// Return the address of the first free space of size bytes, false if the heap is full
+func (heap *Heap) space(size Addr) (Addr, bool) {
+	if size > HeapEnd-HeapStart {
+		return 0, false
+	}
+	address := HeapStart + heap.guard()
+	for _, block := range heap.blocks {
+		if address+roundUp(size)+heap.guard() <= block.addr {
+			return address, true
+		}
+		address = block.end() + heap.guard()
+	}
+	return address, address+roundUp(size) <= HeapEnd
+}
+
+// Add a block to the list of the allocated blocks
+func (heap *Heap) insert(block heapBlock) {
+	i := sort.Search(len(heap.blocks), func(i int) bool { return heap.blocks[i].addr > block.addr })
+	heap.blocks = append(heap.blocks, heapBlock{})
+	copy(heap.blocks[i+1:], heap.blocks[i:])
+	heap.blocks[i] = block
+	delete(heap.freed, block.addr)
+}
+
+// Remove a block from the list of the allocated blocks
+func (heap *Heap) remove(i int) {
+	if heap.debug {
+		heap.freed[heap.blocks[i].addr] = true
+	}
+	heap.blocks = append(heap.blocks[:i], heap.blocks[i+1:]...)
+}
+
+// Execute a command
+func (heap *Heap) execute(command Word) {
+	address, size := Addr(heap.address), Addr(heap.size)
+	switch command {
+	case HeapAllocate:
+		heap.ior = AllocateError
+		heap.address = 0
+		if address, ok := heap.space(size); ok {
+			heap.insert(heapBlock{address, size})
+			heap.address = Word(address)
+			heap.ior = 0
+		}
+	case HeapFree:
+		heap.ior = FreeError
+		if i := heap.find(address); i != -1 {
+			heap.remove(i)
+			heap.ior = 0
+		} else if heap.debug && heap.freed[address] {
+			heap.bus.Fault(&HeapError{"double free", address})
+		} else if heap.debug {
+			heap.bus.Fault(&HeapError{"free of a block not allocated", address})
+		}
+	case HeapResize:
+		heap.ior = ResizeError
+		i := heap.find(address)
+		if i == -1 {
+			if heap.debug {
+				heap.bus.Fault(&HeapError{"resize of a block not allocated", address})
+			}
+			return
+		}
+		if size > HeapEnd-HeapStart {
+			return
+		}
+		block := heap.blocks[i]
+		next := HeapEnd
+		if i+1 < len(heap.blocks) {
+			next = heap.blocks[i+1].addr
+		}
+		if block.addr+roundUp(size)+heap.guard() <= next { // resize in place
+			heap.blocks[i].size = size
+			heap.ior = 0
+		} else if moved, ok := heap.space(size); ok {
+			heap.insert(heapBlock{moved, size})
+			heap.bus.Move(block.addr, moved, block.size)
+			heap.remove(heap.find(block.addr))
+			heap.address = Word(moved)
+			heap.ior = 0
+		}
+	}
+}
+
+// Check a write into the heap memory (in debug mode)
+func (heap *Heap) checkWrite(address Addr, size Addr) error {
+	i := sort.Search(len(heap.blocks), func(i int) bool { return heap.blocks[i].addr > address }) - 1
+	if i < 0 || address+size > heap.blocks[i].addr+heap.blocks[i].size {
+		return &HeapError{"write outside the allocated blocks", address}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package fcpu
+
+import (
+	"errors"
+	"testing"
+)
+
+// Execute a heap command, writing the registers with the bus
+func heapCommand(bus *Bus, command Word, address Addr, size Addr) (Addr, Word) {
+	bus.WriteW(HeapRegisters+HeapAddress, Word(address))
+	bus.WriteW(HeapRegisters+HeapSize, Word(size))
+	bus.WriteW(HeapRegisters+HeapCommand, command)
+	return Addr(bus.ReadW(HeapRegisters + HeapAddress)), bus.ReadW(HeapRegisters + HeapIor)
+}
+
+func TestHeap(t *testing.T) {
+	cpu := New()
+	bus := cpu.bus
+	a1, ior := heapCommand(bus, HeapAllocate, 0, 10)
+	if ior != 0 || a1 != HeapStart {
+		t.Fatalf("allocate: expected %08x 0, got %08x %d", HeapStart, a1, ior)
+	}
+	a2, _ := heapCommand(bus, HeapAllocate, 0, 4)
+	if a2 != a1+12 {
+		t.Fatalf("allocate: expected %08x, got %08x", a1+12, a2)
+	}
+	if _, ior = heapCommand(bus, HeapAllocate, 0, Addr(HeapEnd)); ior != AllocateError {
+		t.Errorf("allocate: expected %d, got %d", AllocateError, ior)
+	}
+
+	if a, ior := heapCommand(bus, HeapResize, a1, Addr(HeapEnd)); ior != ResizeError || a != a1 {
+		t.Errorf("resize: expected %08x %d, got %08x %d", a1, ResizeError, a, ior)
+	}
+
+	// Resize in place and moving the block
+	if a, ior := heapCommand(bus, HeapResize, a1, 12); ior != 0 || a != a1 {
+		t.Errorf("resize: expected %08x 0, got %08x %d", a1, a, ior)
+	}
+	bus.WriteBytes(a1, []byte("hello"))
+	a3, ior := heapCommand(bus, HeapResize, a1, 100)
+	if ior != 0 || a3 == a1 || string(bus.ReadBytes(a3, 5)) != "hello" {
+		t.Errorf("resize: expected the block moved, got %08x %d", a3, ior)
+	}
+
+	// The freed space is reused
+	if _, ior = heapCommand(bus, HeapFree, a2, 0); ior != 0 {
+		t.Errorf("free: expected 0, got %d", ior)
+	}
+	if _, ior = heapCommand(bus, HeapFree, a2, 0); ior != FreeError {
+		t.Errorf("free: expected %d, got %d", FreeError, ior)
+	}
+	if a, _ := heapCommand(bus, HeapAllocate, 0, 16); a != a1 {
+		t.Errorf("allocate: expected %08x, got %08x", a1, a)
+	}
+	if err := bus.takeFault(); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+}
+
+func TestHeapDebug(t *testing.T) {
+	cpu := New()
+	cpu.Heap.Debug()
+	bus := cpu.bus
+	a, _ := heapCommand(bus, HeapAllocate, 0, 6)
+	bus.WriteBytes(a, []byte("hello!"))
+	if err := bus.takeFault(); err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	var heapError *HeapError
+	bus.WriteB(a+6, '?')
+	if err := bus.takeFault(); !errors.As(err, &heapError) || heapError.Addr != a+6 {
+		t.Errorf("expected write outside the allocated blocks, got %v", err)
+	}
+	if bus.ReadB(a+6) != 0 {
+		t.Errorf("the write outside the block was executed")
+	}
+	heapCommand(bus, HeapFree, a, 0)
+	bus.WriteW(a, 1)
+	if err := bus.takeFault(); !errors.As(err, &heapError) {
+		t.Errorf("expected write into a freed block, got %v", err)
+	}
+	heapCommand(bus, HeapFree, a, 0)
+	if err := bus.takeFault(); !errors.As(err, &heapError) || heapError.Message != "double free" {
+		t.Errorf("expected double free, got %v", err)
+	}
+
+	// The error stops the execution
+	cpu.Ds.Push(Word(HeapStart))
+	if err := runProgram(cpu, []byte{PUSH_B, 1, SWAP, STORE, byte(HLT)}); !errors.As(err, &heapError) {
+		t.Errorf("expected heap error, got %v", err)
+	}
+}
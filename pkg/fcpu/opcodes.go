@@ -81,4 +81,27 @@ const (
 	PUSHRBP = POP0 + iota /* Push RBP */
 	POPRBP  = POP1 + iota /* Pop -> RBP */
 	PUSHPC  = POP0 + iota /* Push PC */
+
+	/* Input */
+	KEY = POP0 + iota /* Read a char from the input (-1 at the end of the input) */
+
+	/* Double-cell arithmetic (the high cell of a double-cell number is on the top) */
+	ADDC  = POP2 + iota /* Add with carry ( u1 u2 -- u3 carry ) */
+	UMUL  = POP2 + iota /* Unsigned multiply ( u1 u2 -- ud ) */
+	MMUL  = POP2 + iota /* Signed multiply ( n1 n2 -- d ) */
+	UMDIV = POP2 + iota /* Unsigned divide ( ud u1 -- u2 u3 ) remainder and quotient, pops 3 items */
+	SMDIV = POP2 + iota /* Signed symmetric divide ( d n1 -- n2 n3 ) remainder and quotient, pops 3 items */
+
+	/* Unsigned arithmetic and comparison */
+	ARSHIFT = POP2 + iota /* Perform an arithmetic right shift */
+	UDIV    = POP2 + iota /* Unsigned divide */
+	UMOD    = POP2 + iota /* Unsigned modulo */
+	UGT     = POP2 + iota /* Unsigned compare for Greater */
+	ULT     = POP2 + iota /* Unsigned compare for Less */
+
+	/* Floating-point unit */
+	FPU = POP0 + iota /* Floating-point instruction, followed by the floating-point operation (FOp) */
+
+	/* Extended instructions */
+	EXT = POP0 + iota /* Extended instruction, followed by the extended operation (XOp) */
 )
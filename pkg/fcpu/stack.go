@@ -7,6 +7,7 @@ import (
 
 type Stack struct {
 	bus     *Bus
+	top     Addr // initial origin
 	origin  Addr
 	pointer Addr
 }
@@ -14,6 +15,7 @@ type Stack struct {
 func NewStack(bus *Bus, origin Addr) (stack *Stack) {
 	stack = new(Stack)
 	stack.bus = bus
+	stack.top = origin
 	stack.origin = origin
 	stack.pointer = origin
 	return stack
@@ -92,6 +94,22 @@ func (stack *Stack) Pop2() (Word, Word, error) {
 	return v1, v2, nil
 }
 
+// Check if more items than pushed have been removed from the stack
+func (stack *Stack) Underflow() bool {
+	return stack.pointer > stack.origin
+}
+
+// Remove all the items from the stack
+func (stack *Stack) Reset() {
+	stack.pointer = stack.origin
+}
+
+// Remove all the items from the stack and restore the initial origin (moved by POPRBP and POPSB)
+func (stack *Stack) ResetOrigin() {
+	stack.origin = stack.top
+	stack.pointer = stack.top
+}
+
 func (stack *Stack) Size() Addr {
 	return (stack.origin - stack.pointer) / WordSize
 }
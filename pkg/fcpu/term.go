@@ -9,11 +9,13 @@ type Terminal struct {
 	start Addr
 	ready Word
 	out   Word
+	stop  chan struct{} // closed to stop the clock
 }
 
 func NewTerminal() (term *Terminal) {
 	term = new(Terminal)
 	term.start = MemoryLimit
+	term.stop = make(chan struct{})
 	go term.clock()
 	return term
 }
@@ -46,8 +48,19 @@ func (term *Terminal) WriteW(address Addr, value Word) {
 	// fmt.Println("x")
 }
 
+// Stop the clock
+func (term *Terminal) Close() error {
+	close(term.stop)
+	return nil
+}
+
 func (term *Terminal) clock() {
 	for {
+		select {
+		case <-term.stop:
+			return
+		default:
+		}
 		time.Sleep(1000 / 9600 * 10 * time.Millisecond)
 		if term.ready != 0 {
 			// fmt.Println("aaa", term.out)
@@ -2,11 +2,18 @@ package forth
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
 	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"math"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 var Definitions = map[string]string{
@@ -49,9 +56,29 @@ var Definitions = map[string]string{
 	"MAX":    ";code max ;",
 	"MIN":    ";code min ;",
 	"ABS":    ";code abs ;",
-	"LSHIFT": ";code lshift ;", // Perform a logical left shift
-	"RSHIFT": ";code rshift ;", // Perform a logical right shift
-	"NEGATE": "0 swap -",       // Negate n1, giving its arithmetic inverse n2
+	"LSHIFT": ";code lshift ;",                         // Perform a logical left shift
+	"RSHIFT": ";code rshift ;",                         // Perform a logical right shift
+	"2*":     "1 lshift",                               // ( x1 -- x2 ) Shift x1 one bit toward the most significant bit.
+	"2/":     ";code push 1 arshift ;",                 // ( x1 -- x2 ) Shift x1 one bit toward the least significant bit, leaving the most significant bit unchanged.
+	"U/MOD":  "2dup 2>r ;code umod ; 2r> ;code udiv ;", // ( u1 u2 -- u3 u4 ) Divide u1 by u2, giving the remainder u3 and the quotient u4.
+	"NEGATE": "0 swap -",                               // Negate n1, giving its arithmetic inverse n2
+	"*/MOD":  ">r m* r> sm/rem",                        // ( n1 n2 n3 -- n4 n5 ) Multiply n1 by n2 (double-cell result), divide by n3 giving the remainder n4 and the quotient n5.
+	"*/":     ">r m* r> sm/rem nip",                    // ( n1 n2 n3 -- n4 ) Multiply n1 by n2 (double-cell result), divide by n3 giving the quotient n4.
+
+	/* Double-cell arithmetic (the high cell of a double-cell number is on the top) */
+	"S>D":     "dup 0<",                         // ( n -- d ) Convert the number n to the double-cell number d.
+	"D>S":     "drop",                           // ( d -- n ) Convert the double-cell number d to the number n.
+	"UM*":     ";code umul ;",                   // ( u1 u2 -- ud ) Multiply u1 by u2, giving the unsigned double-cell product ud.
+	"M*":      ";code mmul ;",                   // ( n1 n2 -- d ) Multiply n1 by n2, giving the signed double-cell product d.
+	"UM/MOD":  ";code umdiv ;",                  // ( ud u1 -- u2 u3 ) Divide ud by u1, giving the remainder u2 and the quotient u3.
+	"SM/REM":  ";code smdiv ;",                  // ( d n1 -- n2 n3 ) Divide d by n1 (symmetric division), giving the remainder n2 and the quotient n3.
+	"D+":      "rot + >r ;code addc ; r> +",     // ( d1 d2 -- d3 ) Add d2 to d1, giving the sum d3.
+	"DNEGATE": "swap -1 xor swap -1 xor 1 0 d+", // ( d1 -- d2 ) Negate d1, giving its arithmetic inverse d2.
+	"D-":      "dnegate d+",                     // ( d1 d2 -- d3 ) Subtract d2 from d1, giving the difference d3.
+	"DABS":    "dup 0< if dnegate then",         // ( d -- ud ) ud is the absolute value of d.
+	"D0=":     "or 0=",                          // ( d -- flag ) flag is true if and only if d is equal to zero.
+	"D0<":     "nip 0<",                         // ( d -- flag ) flag is true if and only if d is less than zero.
+	"D=":      "rot = >r = r> and",              // ( d1 d2 -- flag ) flag is true if and only if d1 is equal to d2.
 
 	/* Logical */
 	"AND":    ";code and ;",
@@ -69,9 +96,11 @@ var Definitions = map[string]string{
 	">=": ";code ge ;",
 	"<":  ";code lt ;",
 	"<=": ";code le ;",
-	"0<": "0 <", // ( n -- flag ) flag is true if and only if n is less than zero.
-	"0=": "0 =", // ( x -- flag ) flag is true if and only if x is equal to zero.
-	"0>": "0 >", // ( n -- flag ) flag is true if and only if n is greater than zero.
+	"0<": "0 <",         // ( n -- flag ) flag is true if and only if n is less than zero.
+	"0=": "0 =",         // ( x -- flag ) flag is true if and only if x is equal to zero.
+	"0>": "0 >",         // ( n -- flag ) flag is true if and only if n is greater than zero.
+	"U<": ";code ult ;", // ( u1 u2 -- flag ) flag is true if and only if u1 is less than u2.
+	"U>": ";code ugt ;", // ( u1 u2 -- flag ) flag is true if and only if u1 is greater than u2.
 
 	/* Memory */
 	"!":     ";code store ;",                    // ( x a-addr -- ) Store x at a-addr.
@@ -82,15 +111,94 @@ var Definitions = map[string]string{
 	"2!":    "swap over ! cell+ !",              // ( x1 x2 a-addr -- ) Store the cell pair x1 at a-addr and x2 at the next consecutive cell.
 	"2@":    "dup cell+ @ swap @",               // ( a-addr -- x1 x2 ) Fetch the cell pair x1 x2 stored at a-addr.
 	"CELL+": fmt.Sprintf("%d +", fcpu.WordSize), // ( a-addr1 -- a-addr2 ) Add the word size to a-addr1, giving a-addr2.
+	"CELLS": fmt.Sprintf("%d *", fcpu.WordSize), // ( n1 -- n2 ) n2 is the size in address units of n1 cells.
+	"CHAR+": "1 +",                              // ( c-addr1 -- c-addr2 ) Add the size of a character to c-addr1, giving c-addr2.
+	"CHARS": "",                                 // ( n1 -- n2 ) n2 is the size in address units of n1 characters.
+
+	/* Memory blocks */
+	"MOVE":   ";code bmove ;",     // ( addr1 addr2 u -- ) Copy u address units from addr1 to addr2 (the blocks can overlap).
+	"CMOVE":  ";code bmoveup ;",   // ( c-addr1 c-addr2 u -- ) Copy u characters from c-addr1 to c-addr2, proceeding from lower addresses to higher addresses.
+	"CMOVE>": ";code bmovedown ;", // ( c-addr1 c-addr2 u -- ) Copy u characters from c-addr1 to c-addr2, proceeding from higher addresses to lower addresses.
+	"FILL":   ";code bfill ;",     // ( c-addr u char -- ) Store char in each of u consecutive characters of memory beginning at c-addr.
+	"ERASE":  "0 fill",            // ( addr u -- ) Clear all bits in each of u consecutive address units of memory beginning at addr.
+	"BLANK":  "bl fill",           // ( c-addr u -- ) Store a space in each of u consecutive characters of memory beginning at c-addr.
+
+	/* Data space */
+	"HERE":    fmt.Sprintf(";code push %s fetch ;", herePointer),                                // ( -- addr ) addr is the data-space pointer.
+	"ALLOT":   fmt.Sprintf(";code push %s fetch add push %s store ;", herePointer, herePointer), // ( n -- ) Reserve n address units of data space.
+	",":       "here ! 1 cells allot",                                                           // ( x -- ) Reserve one cell of data space and store x in the cell.
+	"C,":      "here c! 1 chars allot",                                                          // ( char -- ) Reserve space for one character in the data space and store char in the space.
+	"ALIGNED": fmt.Sprintf("%d + %d and", fcpu.WordSize-1, -int(fcpu.WordSize)),                 // ( addr -- a-addr ) a-addr is the first aligned address greater than or equal to addr.
+	"ALIGN":   "here dup aligned swap - allot",                                                  // ( -- ) If the data-space pointer is not aligned, reserve enough space to align it.
+
+	/* Floating-point (IEEE 754 binary32 on the floating-point stack, a float is one cell) */
+	"F@":       ";code fload ;",                    // ( a-addr -- ) ( F: -- r ) r is the value stored at a-addr.
+	"F!":       ";code fstore ;",                   // ( a-addr -- ) ( F: r -- ) Store r at a-addr.
+	"FDUP":     ";code fdup ;",                     // ( F: r -- r r ) Duplicate r.
+	"FDROP":    ";code fdrop ;",                    // ( F: r -- ) Remove r from the floating-point stack.
+	"FSWAP":    ";code fswap ;",                    // ( F: r1 r2 -- r2 r1 ) Exchange the top two floating-point stack items.
+	"FOVER":    ";code fover ;",                    // ( F: r1 r2 -- r1 r2 r1 ) Place a copy of r1 on top of the floating-point stack.
+	"FROT":     ";code fpop fswap fpush fswap ;",   // ( F: r1 r2 r3 -- r2 r3 r1 ) Rotate the top three floating-point stack entries.
+	"FDEPTH":   ";code fdepth ;",                   // ( -- +n ) +n is the number of values contained on the floating-point stack.
+	"F+":       ";code fadd ;",                     // ( F: r1 r2 -- r3 ) Add r1 to r2 giving the sum r3.
+	"F-":       ";code fsub ;",                     // ( F: r1 r2 -- r3 ) Subtract r2 from r1, giving r3.
+	"F*":       ";code fmul ;",                     // ( F: r1 r2 -- r3 ) Multiply r1 by r2 giving r3.
+	"F/":       ";code fdiv ;",                     // ( F: r1 r2 -- r3 ) Divide r1 by r2, giving the quotient r3.
+	"FNEGATE":  ";code fneg ;",                     // ( F: r1 -- r2 ) r2 is the negation of r1.
+	"FABS":     ";code fabs ;",                     // ( F: r1 -- r2 ) r2 is the absolute value of r1.
+	"FMAX":     ";code fmax ;",                     // ( F: r1 r2 -- r3 ) r3 is the greater of r1 and r2.
+	"FMIN":     ";code fmin ;",                     // ( F: r1 r2 -- r3 ) r3 is the lesser of r1 and r2.
+	"F<":       ";code flt ;",                      // ( -- flag ) ( F: r1 r2 -- ) flag is true if and only if r1 is less than r2.
+	"F=":       ";code feq ;",                      // ( -- flag ) ( F: r1 r2 -- ) flag is true if and only if r1 is equal to r2.
+	"F0<":      ";code push 0 fpush flt ;",         // ( -- flag ) ( F: r -- ) flag is true if and only if r is less than zero.
+	"F0=":      ";code push 0 fpush feq ;",         // ( -- flag ) ( F: r -- ) flag is true if and only if r is equal to zero.
+	"S>F":      ";code itof ;",                     // ( n -- ) ( F: -- r ) r is the floating-point equivalent of n.
+	"F>S":      ";code ftoi ;",                     // ( -- n ) ( F: r -- ) n is the integer part of r.
+	"D>F":      ";code dtof ;",                     // ( d -- ) ( F: -- r ) r is the floating-point equivalent of d.
+	"F>D":      ";code ftod ;",                     // ( -- d ) ( F: r -- ) d is the double-cell integer part of r.
+	"FLOOR":    ";code ffloor ;",                   // ( F: r1 -- r2 ) Round r1 to an integral value using the "round toward negative infinity" rule.
+	"FROUND":   ";code fround ;",                   // ( F: r1 -- r2 ) Round r1 to an integral value using the "round to nearest" rule.
+	"FTRUNC":   ";code ftrunc ;",                   // ( F: r1 -- r2 ) Round r1 to an integral value using the "round toward zero" rule.
+	"FSQRT":    ";code fsqrt ;",                    // ( F: r1 -- r2 ) r2 is the square root of r1.
+	"FSIN":     ";code fsin ;",                     // ( F: r1 -- r2 ) r2 is the sine of the radian angle r1.
+	"FCOS":     ";code fcos ;",                     // ( F: r1 -- r2 ) r2 is the cosine of the radian angle r1.
+	"FTAN":     ";code ftan ;",                     // ( F: r1 -- r2 ) r2 is the tangent of the radian angle r1.
+	"FATAN2":   ";code fatan2 ;",                   // ( F: r1 r2 -- r3 ) r3 is the radian angle whose tangent is r1/r2.
+	"FEXP":     ";code fexp ;",                     // ( F: r1 -- r2 ) Raise e to the power r1, giving r2.
+	"FLN":      ";code fln ;",                      // ( F: r1 -- r2 ) r2 is the natural logarithm of r1.
+	"F**":      ";code fpow ;",                     // ( F: r1 r2 -- r3 ) Raise r1 to the power r2, giving the product r3.
+	"F.":       ";code fprint ; space",             // ( F: r -- ) Display, with a trailing space, r in fixed-point notation.
+	"FLOATS":   fmt.Sprintf("%d *", fcpu.WordSize), // ( n1 -- n2 ) n2 is the size in address units of n1 floating-point numbers.
+	"FLOAT+":   fmt.Sprintf("%d +", fcpu.WordSize), // ( f-addr1 -- f-addr2 ) Add the size of a floating-point number to f-addr1, giving f-addr2.
+	"FALIGNED": "aligned",                          // ( addr -- f-addr ) f-addr is the first float-aligned address greater than or equal to addr.
+	"FALIGN":   "align",                            // ( -- ) If the data-space pointer is not float aligned, reserve enough space to align it.
+
+	/* Strings */
+	"TYPE":   "begin dup while swap dup c@ emit 1+ swap 1- repeat 2drop", // ( c-addr u -- ) Display the character string specified by c-addr and u.
+	"COUNT":  "dup 1+ swap c@",                                           // ( c-addr1 -- c-addr2 u ) Return the character string specification for the counted string stored at c-addr1.
+	"CR":     "10 emit",                                                  // ( -- ) Cause subsequent output to appear at the beginning of the next line.
+	"SPACE":  "bl emit",                                                  // ( -- ) Display one space.
+	"SPACES": "begin dup 0> while space 1- repeat drop",                  // ( n -- ) If n is greater than zero, display n spaces.
+
+	/* Numeric output */
+	"BASE":    fmt.Sprintf(";code push %s ;", numberBase),                                                          // ( -- a-addr ) a-addr is the address of the cell containing the current number-conversion radix.
+	"DECIMAL": fmt.Sprintf(";code push 10 push %s store ;", numberBase),                                            // ( -- ) Set the numeric conversion radix to ten.
+	"HEX":     fmt.Sprintf(";code push 16 push %s store ;", numberBase),                                            // ( -- ) Set the numeric conversion radix to sixteen.
+	"<#":      fmt.Sprintf(";code push %s push %s store ;", holdEnd, holdPointer),                                  // ( -- ) Initialize the pictured numeric output conversion process.
+	"HOLD":    fmt.Sprintf(";code push %s fetch push 1 sub dup push %s store store_b ;", holdPointer, holdPointer), // ( char -- ) Add char to the beginning of the pictured numeric output string.
+	"SIGN":    "0< if 45 hold then",                                                                                // ( n -- ) If n is negative, add a minus sign to the beginning of the pictured numeric output string.
+	"#>":      fmt.Sprintf("2drop ;code push %s fetch push %s over sub ;", holdPointer, holdEnd),                   // ( xd -- c-addr u ) Make the pictured numeric output string available as a character string.
 
 	/* Misc */
-	"EMIT": ";code emit ;",
-	".":    ";code period ;",
-	"HLT":  ";code hlt ;",
-	"NOP":  ";code nop ;",
-	"CALL": ";code call ;",
-	"JMP":  ";code jmp ;",
-	"RET":  ";code ret ;",
+	"EMIT":    ";code emit ;",
+	"KEY":     ";code key ;",  // ( -- char ) Receive one character, -1 at the end of the input.
+	"EXECUTE": ";code call ;", // ( i*x xt -- j*x ) Remove xt from the stack and perform the semantics identified by it.
+	"HLT":     ";code hlt ;",
+	"ABORT":   "-1 throw", // ( i*x -- ) Perform -1 THROW, the program terminates if there is no exception frame
+	"NOP":     ";code nop ;",
+	"CALL":    ";code call ;",
+	"JMP":     ";code jmp ;",
+	"RET":     ";code ret ;",
 }
 
 type Pass uint8
@@ -100,8 +208,13 @@ const (
 	Second      = 2
 )
 
+// Maximum number of errors reported by a compilation pass
+const maxErrors = 10
+
+// Compiler error, the position is set for the errors in a source file (it implements asm.Diagnostic)
 type CompilerError struct {
 	message string
+	asm.Pos
 }
 
 var Constants = map[string]int{
@@ -118,19 +231,143 @@ func (e *CompilerError) Error() string {
 	return fmt.Sprintf("Forth compiler error: %s", e.message)
 }
 
+// Standard words, shared by the compilations and never modified
+type wordSet struct {
+	definitions map[string]string // words compiled inline
+	constants   map[string]int
+	library     map[string]string // words compiled as subroutines, only if used
+}
+
+// Compiler
+//
+// A compiler owns a copy of the standard words (Definitions, Constants and
+// Library), optionally extended with other words. Every compilation works on
+// its own copy of the dictionary, so the definitions of a program are not
+// visible to the following compilations and a compiler can be used by many
+// goroutines at the same time.
+type Compiler struct {
+	mutex    sync.Mutex
+	standard *wordSet
+}
+
+func NewCompiler() *Compiler {
+	compiler := new(Compiler)
+	compiler.standard = &wordSet{
+		definitions: cloneMap(Definitions),
+		constants:   cloneMap(Constants),
+		library:     cloneMap(Library),
+	}
+	return compiler
+}
+
+// Return the standard words, the result must not be modified
+func (compiler *Compiler) words() *wordSet {
+	compiler.mutex.Lock()
+	defer compiler.mutex.Unlock()
+	return compiler.standard
+}
+
+// Replace the standard words with a modified copy (the previous words can be in use)
+func (compiler *Compiler) update(modify func(words *wordSet)) {
+	compiler.mutex.Lock()
+	defer compiler.mutex.Unlock()
+	words := &wordSet{
+		definitions: cloneMap(compiler.standard.definitions),
+		constants:   cloneMap(compiler.standard.constants),
+		library:     cloneMap(compiler.standard.library),
+	}
+	modify(words)
+	compiler.standard = words
+}
+
+// Add words compiled inline, the definitions are Forth source (see Definitions)
+func (compiler *Compiler) AddDefinitions(definitions map[string]string) {
+	compiler.update(func(words *wordSet) {
+		for name, definition := range definitions {
+			words.definitions[strings.ToUpper(name)] = definition
+		}
+	})
+}
+
+// Add constants
+func (compiler *Compiler) AddConstants(constants map[string]int) {
+	compiler.update(func(words *wordSet) {
+		for name, value := range constants {
+			words.constants[strings.ToUpper(name)] = value
+		}
+	})
+}
+
+// Add a library of words, compiled as subroutines only if used by the program (see Library)
+func (compiler *Compiler) AddLibrary(library map[string]string) {
+	compiler.update(func(words *wordSet) {
+		for name, definition := range library {
+			words.library[strings.ToUpper(name)] = definition
+		}
+	})
+}
+
 // Compiler status
 type CompilerStatus struct {
-	output     *os.File
-	labels     map[string]bool
-	constants  map[string]int
-	pass       Pass // pass number (First/Second)
-	context    *ContextStack
-	buf        strings.Builder
-	dictionary map[string]string
+	output         io.StringWriter
+	labels         map[string]bool
+	constants      map[string]int
+	pass           Pass // pass number (First/Second)
+	context        *ContextStack
+	buf            strings.Builder
+	dictionary     map[string]string
+	literals       []string          // compile-time stack of literals (numbers and labels)
+	floats         []float32         // compile-time floating-point stack
+	data           strings.Builder   // data segment
+	dataSize       int               // size of the compile-time data space
+	hereId         int               // last compile-time HERE label id
+	current        string            // name of the word being defined
+	currentLabel   string            // label of the word being defined
+	defining       map[string]string // defining words (words containing CREATE), map names to DOES> labels
+	used           map[string]int    // number of definitions of the word labels
+	stringId       int               // last string literal label id
+	base           int               // radix of the numbers at compile time
+	library        map[string]bool   // library words used by the program
+	pending        []subroutine      // subroutines to be compiled
+	xts            map[string]string // labels of the subroutines executing the words without a label
+	console        io.Writer         // output of the words executed at compile time
+	last           string            // name of the last colon definition
+	immediate      map[string]bool   // immediate words
+	evaluator      bool              // compiling code executed at compile time
+	interpreting   bool              // interpretation state (between [ and ])
+	interpretation strings.Builder   // words to be executed at compile time
+	nesting        int               // CompileLine nesting level (1 for the program source)
+	recording      bool              // recording the source of the current definition
+	source         strings.Builder   // source of the current definition
+	prelude        strings.Builder   // source of the definitions, compiled before the code executed at compile time
+	machine        *Repl             // machine executing the code at compile time (created by the first execution)
+	loaded         int               // length of the prelude loaded into the machine
+	replacement    *string           // text recorded instead of the source of the last token
+	session        bool              // compiling a unit of a session
+	standard       *wordSet          // standard words of the compiler
+	file           string            // name of the source file (empty for the code executed at compile time and the sessions)
+	line           int               // number of the line being compiled
+	locals         map[string]int    // offsets of the locals of the current definition from RBP (nil without locals)
+	values         map[string]string // labels of the cells of the values
+	deferred       map[string]string // labels of the cells containing the execution tokens of the deferred words
 }
 
-func NewCompilerStatus(pass Pass, output *os.File, labels map[string]bool, constants map[string]int) (status *CompilerStatus) {
+// Return a copy of a map
+func cloneMap[V any](m map[string]V) map[string]V {
+	result := make(map[string]V, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+func NewCompilerStatus(pass Pass, output io.StringWriter, labels map[string]bool, constants map[string]int) (status *CompilerStatus) {
+	return newCompilerStatus(NewCompiler().words(), pass, output, labels, constants)
+}
+
+func newCompilerStatus(standard *wordSet, pass Pass, output io.StringWriter, labels map[string]bool, constants map[string]int) (status *CompilerStatus) {
 	status = new(CompilerStatus)
+	status.standard = standard
 	status.pass = pass
 	status.output = output
 	status.context = new(ContextStack)
@@ -142,9 +379,19 @@ func NewCompilerStatus(pass Pass, output *os.File, labels map[string]bool, const
 	if constants != nil {
 		status.constants = constants
 	} else {
-		status.constants = Constants
+		// The program definitions are not added to the compiler maps
+		status.constants = cloneMap(standard.constants)
 	}
-	status.dictionary = Definitions
+	status.dictionary = cloneMap(standard.definitions)
+	status.defining = map[string]string{}
+	status.used = map[string]int{}
+	status.console = os.Stdout
+	status.base = 10
+	status.library = map[string]bool{}
+	status.xts = map[string]string{}
+	status.immediate = map[string]bool{}
+	status.values = map[string]string{}
+	status.deferred = map[string]string{}
 	return status
 }
 
@@ -161,40 +408,187 @@ func (status *CompilerStatus) WriteString(s string) {
 	}
 }
 
+// Read the name following a defining word
+func nextName(tokens *Tokenizer, word string) (string, error) {
+	name, ok := tokens.Word()
+	if !ok {
+		return "", NewCompilerError(fmt.Sprintf("missing %s name", strings.ToLower(word)))
+	}
+	return strings.ToUpper(name), nil
+}
+
+// Read the text following a parsing word, up to the delimiter
+func parseText(tokens *Tokenizer, word string, delimiter byte) (string, error) {
+	text, found := tokens.Parse(delimiter)
+	if !found {
+		return "", NewCompilerError(fmt.Sprintf("%s: missing %c", strings.ToLower(word), delimiter))
+	}
+	return text, nil
+}
+
+// Compile the index of an outer loop
+func (status *CompilerStatus) loopIndex(word string, depth int) error {
+	if status.context.Count(Do) <= depth {
+		return NewCompilerError(fmt.Sprintf("Unbalanced control structure '%s'", strings.ToLower(word)))
+	}
+	if status.pass == Second {
+		// Move the parameters of the inner loops to the data stack, fetch the index and restore the parameters
+		status.Add("  " + strings.Repeat("r_from r_from ", depth) + "r_fetch")
+		status.Add("  " + strings.TrimSpace(strings.Repeat("swap to_r ", 2*depth)))
+	}
+	return nil
+}
+
+// Convert a number, using the current radix (prefixes as 0x are allowed in decimal)
+// The prefixes # $ % specify a decimal, hexadecimal or binary number
+func (status *CompilerStatus) parseNumber(token string) (int64, bool) {
+	number, err := status.parseInt(token)
+	if err != nil || number < math.MinInt32 || number > math.MaxUint32 {
+		return 0, false
+	}
+	return int64(int32(number)), true
+}
+
+// Parse a double-cell number, a number followed by a decimal point (e.g. 123.)
+func (status *CompilerStatus) parseDouble(token string) (int64, bool) {
+	if len(token) < 2 || !strings.HasSuffix(token, ".") {
+		return 0, false
+	}
+	number, err := status.parseInt(strings.TrimSuffix(token, "."))
+	return number, err == nil
+}
+
+// Regular expression matching the floating-point numbers (e.g. 1E 1.5E0 -2.5e-3)
+var floatPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]*)?[Ee][+-]?[0-9]*$`)
+
+// Parse a floating-point number, recognized only if the radix is decimal
+func (status *CompilerStatus) parseFloat(token string) (float32, bool) {
+	if status.base != 10 || !floatPattern.MatchString(token) {
+		return 0, false
+	}
+	if strings.HasSuffix(token, "E") || strings.HasSuffix(token, "+") || strings.HasSuffix(token, "-") {
+		token += "0"
+	}
+	r, err := strconv.ParseFloat(token, 32)
+	return float32(r), err == nil
+}
+
+// Format a floating-point number as a literal, parsed back to the same value
+func formatFloat(r float32) string {
+	return strconv.FormatFloat(float64(r), 'E', -1, 32)
+}
+
+// Compile a floating-point number, moving its bits from the data stack to the floating-point stack
+func (status *CompilerStatus) compileFloat(r float32) {
+	if status.pass == Second {
+		status.WriteString(fmt.Sprintf("  push %d fpush", int32(math.Float32bits(r))))
+	}
+}
+
+// Parse an integer with the current radix or with a radix prefix (# decimal, $ hexadecimal, % binary)
+func (status *CompilerStatus) parseInt(token string) (int64, error) {
+	base := status.base
+	if prefix, exists := map[byte]int{'#': 10, '$': 16, '%': 2}[token[0]]; exists {
+		base = prefix
+		token = token[1:]
+	} else if base == 10 {
+		base = 0
+	}
+	return strconv.ParseInt(token, base, 64)
+}
+
 // Compile a line, add compiled code to the program
 func CompileLine(status *CompilerStatus, line string) error {
-	var err error
-	fields := strings.Fields(line)
-	for i := 0; i < len(fields); i++ {
-		token := fields[i]
-
-		if status.context.Is(Paren) { // Parenthesis comments
-			t := strings.SplitN(token, ")", 2)
-			if len(t) < 2 {
-				continue
-			}
-			status.context.Exit()
-			token = t[1]
-			if len(token) == 0 {
-				continue
-			}
-		} else if status.context.Is(Code) {
+	tokens := NewTokenizer(line)
+	if status.context.Is(Paren) { // Parenthesis comment continued from the previous line
+		if _, found := tokens.Parse(')'); !found {
+			return nil
+		}
+		status.context.Exit()
+	}
+	status.nesting++
+	defer func() { status.nesting-- }()
+	// Compile a token, the parsing words read the following text from the tokenizer
+	compileToken := func(token string) error {
+		var err error
+		if status.context.Is(Code) {
 			if token == ";" {
 				status.context.Exit()
 			} else if status.pass == Second {
 				status.WriteString(" " + token)
 			}
-			continue
+			return nil
+		}
+
+		if status.interpreting && token != "]" { // Words executed at compile time
+			status.replace("")
+			return status.interpretToken(tokens, token)
 		}
 
 		token = strings.ToUpper(token)
 		if strings.HasPrefix(token, "\\") { // Start of comment. The rest of the current line is ignored.
-			break
+			tokens.SkipLine()
+			status.replace("")
+			return nil
+		}
+		if token == "(" { // Paren
+			if _, found := tokens.Parse(')'); !found {
+				status.context.Enter(Paren)
+			}
+			status.replace("")
+			return nil
+		}
+
+		// Locals of the current definition
+		if status.compileLocal(token) {
+			return nil
+		}
+
+		// Data space words executed at compile time
+		if executed, err := status.compileTime(token); executed {
+			if err != nil {
+				return err
+			}
+			return nil
 		}
 
 		definition, hasDefinition := status.dictionary[token]
+		if !hasDefinition {
+			definition, hasDefinition = status.libraryWord(token)
+		}
 		_, isLabel := status.labels[token]
 		constantValue, isConstant := status.constants[token]
+		number, isNumber := status.parseNumber(token)
+		double, isDouble := status.parseDouble(token)
+		lo, hi := int(int32(double)), int(int32(double>>32))
+		float, isFloat := status.parseFloat(token)
+
+		// Outside colon definitions, literals are kept on the compile-time stack
+		if !status.inDefinition() {
+			switch {
+			case isConstant:
+				status.pushNumber(constantValue)
+				return nil
+			case isLabel:
+				status.pushLiteral(token)
+				return nil
+			case isNumber && !hasDefinition:
+				status.pushNumber(int(number))
+				return nil
+			case isDouble && !hasDefinition:
+				status.pushNumber(lo)
+				status.pushNumber(hi)
+				return nil
+			case isFloat && !hasDefinition:
+				status.floats = append(status.floats, float)
+				return nil
+			case token == "CONSTANT" || token == "2CONSTANT" || token == "FCONSTANT" || token == "VALUE" || token == "SEMAPHORE" || token == "'":
+			case hasDefinition:
+				// The expansion of the definition compiles the literals if needed
+			default:
+				status.flushLiterals()
+			}
+		}
 
 		switch {
 		case token == "IF":
@@ -233,24 +627,50 @@ func CompileLine(status *CompilerStatus, line string) error {
 				status.Add("do_{ID}:")
 			}
 
+		case token == "?DO": // Like DO, but the loop is skipped if the limit is equal to the index
+			status.context.Enter(Do)
+			if status.pass == Second {
+				status.Add("  swap to_r to_r")           // Push limit, i on the return stack
+				status.Add("  r_from r_fetch over to_r") // Push i, limit
+				status.Add("  eq push do_{ID}_end jnz")  // Skip the loop if limit = i
+				status.Add("do_{ID}:")
+			}
+
 		case token == "I":
-			if !status.context.Is(Do) {
+			if !status.context.HasAnchestor(Do) {
 				return NewCompilerError("Unbalanced control structure 'i'")
 			}
 			if status.pass == Second {
 				status.Add("  r_fetch") // Fetch i from the return stack
 			}
-			break
 
-		case token == "LOOP":
+		case token == "J": // Index of the outer loop
+			if err = status.loopIndex(token, 1); err != nil {
+				return err
+			}
+
+		case token == "K": // Index of the second outer loop
+			if err = status.loopIndex(token, 2); err != nil {
+				return err
+			}
+
+		case token == "LOOP", token == "+LOOP":
 			if !status.context.Is(Do) {
-				return NewCompilerError("Unbalanced control structure 'loop'")
+				return NewCompilerError(fmt.Sprintf("Unbalanced control structure '%s'", strings.ToLower(token)))
 			}
 			if status.pass == Second {
-				status.Add("  r_from r_fetch swap") // Push limit, i
-				status.Add("  push 1 add")          // Increment i
-				status.Add("  dup to_r")            // Store i on the return stack
-				status.Add("  gt push do_{ID} jnz") // Loop
+				if token == "LOOP" {
+					status.Add("  push 1") // Increment
+				}
+				// The loop terminates when the index crosses the boundary between limit-1 and limit:
+				// d = i - limit, exit if ((d xor (d + n)) and (d xor n)) < 0
+				status.Add("  r_from r_fetch sub")    // n d
+				status.Add("  over over xor to_r")    // n d ( R: limit d^n )
+				status.Add("  dup to_r add")          // d+n ( R: limit d^n d )
+				status.Add("  dup r_from xor")        // d+n d^(d+n)
+				status.Add("  r_from and push 0 lt")  // d+n flag ( R: limit )
+				status.Add("  swap r_fetch add to_r") // Store the new index on the return stack
+				status.Add("  push do_{ID} jz")       // Loop
 				status.Add("do_{ID}_end:")
 				status.Add("  r_from drop r_from drop") // Remove limit, i from the return stack
 			}
@@ -258,11 +678,35 @@ func CompileLine(status *CompilerStatus, line string) error {
 
 		case token == "LEAVE":
 			if !status.context.HasAnchestor(Do) {
-				return NewCompilerError("Unbalanced control structure 'loop'")
+				return NewCompilerError("Unbalanced control structure 'leave'")
+			}
+			if status.pass == Second {
+				status.Add(fmt.Sprintf("  push do_%d_end jmp", status.context.AnchestorId(Do))) // Go to end
+			}
+
+		case token == "UNLOOP": // Remove the loop parameters from the return stack
+			if !status.context.HasAnchestor(Do) {
+				return NewCompilerError("Unbalanced control structure 'unloop'")
+			}
+			if status.pass == Second {
+				status.Add("  r_from drop r_from drop")
+			}
+
+		case token == "EXIT": // Return from the current definition
+			if !status.inDefinition() {
+				return NewCompilerError("exit: not allowed outside a definition")
 			}
+			status.compileFrameExit()
 			if status.pass == Second {
-				status.Add("  r_from drop r_fetch to_r") // i := limit
-				status.Add("  push do_{ID}_end jmp")     // Go to end
+				status.Add("  ret")
+			}
+
+		case token == "RECURSE": // Call the current definition
+			if status.currentLabel == "" {
+				return NewCompilerError("recurse: not allowed outside a definition")
+			}
+			if status.pass == Second {
+				status.Add(fmt.Sprintf("  push %s call", status.currentLabel))
 			}
 
 		case token == "BEGIN": // BEGIN ... UNTIL - Loop back to BEGIN until true at UNTIL
@@ -271,6 +715,15 @@ func CompileLine(status *CompilerStatus, line string) error {
 				status.Add("begin_{ID}:")
 			}
 
+		case token == "AGAIN": // BEGIN ... AGAIN - Infinite loop
+			if !status.context.Is(Begin) {
+				return NewCompilerError("Unbalanced control structure 'again'")
+			}
+			if status.pass == Second {
+				status.Add("  push begin_{ID} jmp") // Loop
+			}
+			status.context.Exit()
+
 		case token == "UNTIL":
 			if !status.context.Is(Begin) {
 				return NewCompilerError("Unbalanced control structure 'until'")
@@ -298,6 +751,39 @@ func CompileLine(status *CompilerStatus, line string) error {
 			}
 			status.context.Exit()
 
+		case token == "CASE": // CASE ... OF ... ENDOF ... ENDCASE - Multiple selection
+			status.context.Enter(Case)
+
+		case token == "OF": // ( x1 x2 -- | x1 ) If x1 is equal to x2, execute the code up to ENDOF
+			if !status.context.Is(Case) {
+				return NewCompilerError("Unbalanced control structure 'of'")
+			}
+			status.context.Enter(Of)
+			if status.pass == Second {
+				status.Add("  over eq push of_{ID}_next jz") // Compare the selector
+				status.Add("  drop")                         // Remove the selector
+			}
+
+		case token == "ENDOF":
+			if !status.context.Is(Of) {
+				return NewCompilerError("Unbalanced control structure 'endof'")
+			}
+			if status.pass == Second {
+				status.Add(fmt.Sprintf("  push case_%d_end jmp", status.context.AnchestorId(Case)))
+				status.Add("of_{ID}_next:")
+			}
+			status.context.Exit()
+
+		case token == "ENDCASE": // ( x -- ) Remove the selector
+			if !status.context.Is(Case) {
+				return NewCompilerError("Unbalanced control structure 'endcase'")
+			}
+			if status.pass == Second {
+				status.Add("  drop")
+				status.Add("case_{ID}_end:")
+			}
+			status.context.Exit()
+
 		case token == "?DUP":
 			status.context.Enter(If)
 			if status.pass == Second {
@@ -310,29 +796,404 @@ func CompileLine(status *CompilerStatus, line string) error {
 
 		case token == ":": // Colon
 			status.context.Enter(Colon)
-			i++
-			if i >= len(fields) {
+			name, ok := tokens.Word()
+			if !ok {
 				return NewCompilerError("missing colon definition")
 			}
-			label := strings.ToUpper(fields[i])
-			status.labels[label+"_COL"] = true
-			status.dictionary[label] = fmt.Sprintf("%s_col call", strings.ToLower(label))
+			if status.nesting == 1 {
+				status.recording = true
+				status.source.Reset()
+			}
+			label := status.newLabel(name, "col")
+			status.current = strings.ToUpper(name)
+			status.last = status.current
+			status.currentLabel = label
+			status.labels[strings.ToUpper(label)] = true
+			status.dictionary[status.current] = fmt.Sprintf("%s call", label)
 			if status.pass == Second {
-				status.Add(fmt.Sprintf("%s_col:", strings.ToLower(label)))
+				status.Add(fmt.Sprintf("%s:", label))
 			}
 
 		case token == ";": // Semicolon
 			if !status.context.HasAnchestor(Colon) {
 				return NewCompilerError("not a function")
 			}
+			if !status.context.Is(Colon) {
+				return status.context.Unterminated()[0]
+			}
+			status.compileFrameExit()
 			status.Add("  ret")
 			status.context.Exit()
+			status.current = ""
+			status.currentLabel = ""
+			status.locals = nil
+
+		case token == "{:": // {: args | locals -- outputs :} Declare the locals of the definition
+			return status.compileLocals(tokens)
+
+		case token == "TO": // ( x "name" -- ) Store x into the local or the value name
+			name, err := nextName(tokens, "to")
+			if err != nil {
+				return err
+			}
+			if status.compileLocalStore(name) {
+				break
+			}
+			label, isValue := status.values[name]
+			if !isValue {
+				return NewCompilerError(fmt.Sprintf("to: %s is not a value", strings.ToLower(name)))
+			}
+			return CompileLine(status, label+" !")
+
+		case token == "IS": // ( xt "name" -- ) Set name to execute xt
+			name, err := nextName(tokens, "is")
+			if err != nil {
+				return err
+			}
+			label, isDeferred := status.deferred[name]
+			if !isDeferred {
+				return NewCompilerError(fmt.Sprintf("is: %s is not a deferred word", strings.ToLower(name)))
+			}
+			return CompileLine(status, label+" !")
+
+		case token == "ACTION-OF": // ( "name" -- xt ) Return the execution token name is set to execute
+			name, err := nextName(tokens, "action-of")
+			if err != nil {
+				return err
+			}
+			label, isDeferred := status.deferred[name]
+			if !isDeferred {
+				return NewCompilerError(fmt.Sprintf("action-of: %s is not a deferred word", strings.ToLower(name)))
+			}
+			return CompileLine(status, label+" @")
+
+		case token == "'": // ( "name" -- xt ) Return the execution token of name
+			if status.inDefinition() {
+				return NewCompilerError("': not allowed in a definition, use [']")
+			}
+			name, err := nextName(tokens, token)
+			if err != nil {
+				return err
+			}
+			xt, err := status.executionToken(name)
+			if err != nil {
+				return err
+			}
+			status.pushLiteral(strings.ToUpper(xt))
+
+		case token == "IMMEDIATE": // ( -- ) Make the most recent definition an immediate word
+			if status.inDefinition() || status.last == "" {
+				return NewCompilerError("immediate: definition expected")
+			}
+			status.immediate[status.last] = true
+
+		case token == "[": // Enter interpretation state, the following words are executed at compile time
+			if !status.inDefinition() {
+				return NewCompilerError("[: not allowed outside a definition")
+			}
+			status.interpreting = true
+			status.interpretation.Reset()
+			status.replace("")
+
+		case token == "]": // Enter compilation state, execute the words since [
+			if !status.interpreting {
+				return NewCompilerError("]: [ expected")
+			}
+			status.interpreting = false
+			generated, err := status.evaluate(status.interpretation.String())
+			if err != nil {
+				return err
+			}
+			if err = status.compileGenerated(generated); err != nil {
+				return err
+			}
+
+		case token == "LITERAL": // ( x -- ) Compile x, x is the value on top of the compile-time stack
+			if !status.inDefinition() {
+				return NewCompilerError("literal: not allowed outside a definition")
+			}
+			n, err := status.popNumber(token)
+			if err != nil {
+				return err
+			}
+			if status.pass == Second {
+				status.WriteString(fmt.Sprintf("  push %d", n))
+			}
+			status.replace(fmt.Sprintf("#%d", n))
+
+		case token == "FLITERAL": // ( F: r -- ) Compile r, r is the value on top of the compile-time floating-point stack
+			if !status.inDefinition() {
+				return NewCompilerError("fliteral: not allowed outside a definition")
+			}
+			r, err := status.popFloat(token)
+			if err != nil {
+				return err
+			}
+			status.compileFloat(r)
+			status.replace(formatFloat(r))
+
+		case token == "[CHAR]": // ( "name" -- ) Compile the value of the first character of name
+			name, ok := tokens.Word()
+			if !ok {
+				return NewCompilerError("missing [char] name")
+			}
+			if status.inDefinition() {
+				if status.pass == Second {
+					status.WriteString(fmt.Sprintf("  push %d", name[0]))
+				}
+			} else {
+				status.pushNumber(int(name[0]))
+			}
+			status.replace(fmt.Sprintf("#%d", name[0]))
+
+		case token == "[']": // ( "name" -- ) Compile the execution token of name
+			name, err := nextName(tokens, token)
+			if err != nil {
+				return err
+			}
+			xt, err := status.executionToken(name)
+			if err != nil {
+				return err
+			}
+			if status.pass == Second {
+				status.WriteString(fmt.Sprintf("  push %s", xt))
+			}
+
+		case token == "POSTPONE": // ( "name" -- ) Append the compilation semantics of name to the current definition
+			if !status.inDefinition() {
+				return NewCompilerError("postpone: not allowed outside a definition")
+			}
+			name, err := nextName(tokens, token)
+			if err != nil {
+				return err
+			}
+			switch {
+			case name == "LITERAL":
+				err = CompileLine(status, "(compile-number)")
+			case status.immediate[name]:
+				err = CompileLine(status, status.dictionary[name])
+			default:
+				status.compileString(strings.ToLower(name))
+				err = CompileLine(status, "(compile-text)")
+			}
+			return err
+
+		case hasDefinition && status.immediate[token] && status.inDefinition() && !status.evaluator:
+			// Immediate word, executed at compile time
+			generated, err := status.evaluate(token)
+			if err != nil {
+				return err
+			}
+			if err = status.compileGenerated(generated); err != nil {
+				return err
+			}
+
+		case token == "DOES>": // Define the execution semantics of the words defined by a defining word
+			if !status.context.Is(Colon) {
+				return NewCompilerError("does>: not allowed outside a definition")
+			}
+			if _, isDefining := status.defining[status.current]; !isDefining {
+				return NewCompilerError("does>: create expected")
+			}
+			if status.locals != nil {
+				return NewCompilerError("does>: not allowed in a definition with locals")
+			}
+			// The code before DOES> is executed by the defining word,
+			// the code after DOES> is executed by the defined words
+			label := status.newLabel(status.current, "does")
+			status.labels[strings.ToUpper(label)] = true
+			status.defining[status.current] = label
+			status.Add("  ret")
+			if status.pass == Second {
+				status.Add(fmt.Sprintf("%s:", label))
+			}
+
+		case token == `."`: // ( -- ) Display the text delimited by "
+			text, err := parseText(tokens, token, '"')
+			if err != nil {
+				return err
+			}
+			status.compileString(text)
+			return CompileLine(status, "type")
+
+		case token == `S"`: // ( -- c-addr u ) Return the address and the length of the text delimited by "
+			text, err := parseText(tokens, token, '"')
+			if err != nil {
+				return err
+			}
+			status.compileString(text)
+
+		case token == `C"`: // ( -- c-addr ) Return the address of the counted string delimited by "
+			text, err := parseText(tokens, token, '"')
+			if err != nil {
+				return err
+			}
+			if len(text) > 255 {
+				return NewCompilerError("c\": string too long")
+			}
+			label := status.stringLiteral(text, true)
+			if status.pass == Second {
+				status.WriteString(fmt.Sprintf("  push %s", label))
+			}
+
+		case token == ".(": // ( -- ) Display the text delimited by ) at compile time
+			text, err := parseText(tokens, token, ')')
+			if err != nil {
+				return err
+			}
+			if status.pass == Second {
+				fmt.Fprint(status.console, text)
+			}
+
+		case token == `ABORT"`: // ( x -- ) If x is not zero, perform -2 THROW, displaying the text delimited by " if there is no exception frame
+			text, err := parseText(tokens, token, '"')
+			if err != nil {
+				return err
+			}
+			if err = CompileLine(status, "if"); err != nil {
+				return err
+			}
+			status.compileString(text)
+			return CompileLine(status, `(abort") then`)
 
 		case token == ";CODE": // Code
 			status.context.Enter(Code)
 
-		case token == "(": // Paren
-			status.context.Enter(Paren)
+		case token == "CONSTANT": // ( x "name" -- ) Define a constant
+			if status.inDefinition() {
+				return NewCompilerError("constant: not allowed in a definition")
+			}
+			name, err := nextName(tokens, "constant")
+			if err != nil {
+				return err
+			}
+			value, err := status.popNumber(token)
+			if err != nil {
+				return err
+			}
+			status.constants[name] = value
+			status.addPrelude("#%d constant %s", value, name)
+
+		case token == "2CONSTANT": // ( x1 x2 "name" -- ) Define a constant returning the cell pair x1 x2
+			if status.inDefinition() {
+				return NewCompilerError("2constant: not allowed in a definition")
+			}
+			name, err := nextName(tokens, "2constant")
+			if err != nil {
+				return err
+			}
+			x2, err := status.popNumber(token)
+			if err != nil {
+				return err
+			}
+			x1, err := status.popNumber(token)
+			if err != nil {
+				return err
+			}
+			status.dictionary[name] = fmt.Sprintf("#%d #%d", x1, x2)
+			status.addPrelude("#%d #%d 2constant %s", x1, x2, name)
+
+		case token == "FCONSTANT": // ( F: r -- ) ( "name" -- ) Define a floating-point constant
+			if status.inDefinition() {
+				return NewCompilerError("fconstant: not allowed in a definition")
+			}
+			name, err := nextName(tokens, "fconstant")
+			if err != nil {
+				return err
+			}
+			r, err := status.popFloat(token)
+			if err != nil {
+				return err
+			}
+			status.dictionary[name] = formatFloat(r)
+			status.addPrelude("%s fconstant %s", formatFloat(r), name)
+
+		case dataWords[token] > 0: // ( "name" -- ) Define a word returning the address of the data space reserved by the defining word
+			word := strings.ToLower(token)
+			if status.inDefinition() {
+				return NewCompilerError(word + ": not allowed in a definition")
+			}
+			name, err := nextName(tokens, word)
+			if err != nil {
+				return err
+			}
+			status.align()
+			label := status.newLabel(name, "var")
+			status.dataLabel(label)
+			status.dictionary[name] = label
+			status.allot(dataWords[token])
+			status.addPrelude("%s %s", word, name)
+
+		case token == "SEMAPHORE": // ( n "name" -- ) Define a semaphore, initialized to n
+			if status.inDefinition() {
+				return NewCompilerError("semaphore: not allowed in a definition")
+			}
+			name, err := nextName(tokens, "semaphore")
+			if err != nil {
+				return err
+			}
+			value, err := status.popNumber(token)
+			if err != nil {
+				return err
+			}
+			status.dictionary[name] = status.cell(name, "sem", strconv.Itoa(value))
+			status.addPrelude("#%d semaphore %s", value, name)
+
+		case token == "VALUE": // ( x "name" -- ) Define a value, initialized to x
+			if status.inDefinition() {
+				return NewCompilerError("value: not allowed in a definition")
+			}
+			name, err := nextName(tokens, "value")
+			if err != nil {
+				return err
+			}
+			value, err := status.popLiteral(token)
+			if err != nil {
+				return err
+			}
+			label := status.cell(name, "val", value)
+			status.dictionary[name] = label + " @"
+			status.values[name] = label
+			if _, err := strconv.Atoi(value); err == nil {
+				status.addPrelude("#%s value %s", value, name)
+			} else {
+				status.addPrelude("0 value %s", name)
+			}
+
+		case token == "DEFER": // ( "name" -- ) Define a word executing the execution token set by IS
+			if status.inDefinition() {
+				return NewCompilerError("defer: not allowed in a definition")
+			}
+			name, err := nextName(tokens, "defer")
+			if err != nil {
+				return err
+			}
+			label := status.cell(name, "defer", "0")
+			status.dictionary[name] = label + " (defer)"
+			status.deferred[name] = label
+			status.addPrelude("defer %s", name)
+
+		case token == "CREATE": // ( "name" -- ) Define a word returning the address of the data space
+			if status.inDefinition() {
+				// Defining word, the name is parsed when the defining word is used
+				if _, isDefining := status.defining[status.current]; !isDefining {
+					status.defining[status.current] = ""
+				}
+				err = CompileLine(status, "align")
+				if status.pass == Second {
+					status.Add(fmt.Sprintf("  push %s fetch push %s fetch store", herePointer, createPointer))
+				}
+				break
+			}
+			name, err := nextName(tokens, "create")
+			if err != nil {
+				return err
+			}
+			status.align()
+			label := status.newLabel(name, "var")
+			status.dataLabel(label)
+			status.dictionary[name] = label
+			status.addPrelude("create %s", name)
 
 		case isConstant:
 			if status.pass == Second {
@@ -344,6 +1205,46 @@ func CompileLine(status *CompilerStatus, line string) error {
 				status.WriteString(fmt.Sprintf("  push %s", token))
 			}
 
+		case (token == "DECIMAL" || token == "HEX") && hasDefinition:
+			if err = CompileLine(status, definition); err != nil {
+				return err
+			}
+			if !status.inDefinition() {
+				// The following numbers at top level are converted with the new radix
+				status.base = map[string]int{"DECIMAL": 10, "HEX": 16}[token]
+				status.addPrelude(strings.ToLower(token))
+			}
+
+		case hasDefinition:
+			if does, isDefining := status.defining[token]; isDefining && status.inDefinition() {
+				// A word using a defining word is a defining word
+				status.defining[status.current] = does
+			} else if isDefining {
+				// Defining word: the cell at the label BODY will contain the address of the data space
+				name, err := nextName(tokens, token)
+				if err != nil {
+					return err
+				}
+				status.flushLiterals()
+				status.align()
+				body := status.newLabel(name, "body")
+				status.dataLabel(body)
+				status.allot(int(fcpu.WordSize))
+				if does != "" {
+					status.dictionary[name] = fmt.Sprintf("%s @ %s call", body, does)
+				} else {
+					status.dictionary[name] = fmt.Sprintf("%s @", body)
+				}
+				if status.pass == Second {
+					status.WriteString(fmt.Sprintf("  push %s push %s store", body, createPointer))
+				}
+				status.addPrelude("create %s", name) // the data space of the word is not available at compile time
+			}
+			err = CompileLine(status, definition)
+			if err != nil {
+				return err
+			}
+
 		case strings.HasSuffix(token, ":"): // Define a symbol with the value of the current location counter (used to define labels)
 			label := strings.TrimSuffix(token, ":")
 			status.labels[label] = true
@@ -351,67 +1252,161 @@ func CompileLine(status *CompilerStatus, line string) error {
 				status.WriteString(fmt.Sprintf("\n%s:", label))
 			}
 
-		case hasDefinition:
+		case isNumber:
 			if status.pass == Second {
-				err = CompileLine(status, definition)
-				if err != nil {
-					return err
-				}
+				status.WriteString(fmt.Sprintf("  push %d", number))
+			}
+
+		case isDouble:
+			if status.pass == Second {
+				status.WriteString(fmt.Sprintf("  push %d push %d", lo, hi))
 			}
 
+		case isFloat:
+			status.compileFloat(float)
+
 		default:
 			// Ignore undefined labels/words during the first compilation pass
-			value, err := strconv.ParseInt(token, 0, 0)
-			if i+2 < len(fields) && strings.ToUpper(fields[i+1]) == "CONSTANT" {
-				if status.pass == First {
-					label := strings.ToUpper(fields[i+2])
-					status.constants[label] = int(value)
-				}
-				i = i + 2
-			} else if status.pass == Second {
-				if err != nil {
-					return NewCompilerError(fmt.Sprintf("%s ?", strings.ToLower(token)))
-				}
-				status.WriteString(fmt.Sprintf("  push %d", value))
+			if status.pass == Second {
+				return NewCompilerError(fmt.Sprintf("%s ?", strings.ToLower(token)))
 			}
 		}
-		if err != nil {
-			return err
+		return err
+	}
+	for {
+		token, ok := tokens.Word()
+		if !ok {
+			break
+		}
+		start := tokens.pos - len(token)
+		if status.nesting == 1 {
+			status.context.Token = token
+			status.context.Pos = status.position(tokens.line, start)
+		}
+		if err := compileToken(token); err != nil {
+			return status.locate(err)
+		}
+		if status.nesting == 1 {
+			// Record the source of the definitions, for the compile-time evaluator
+			status.record(tokens.line[start:tokens.pos])
 		}
 	}
 	return nil
 }
 
+// Return the position of the char at offset in the line being compiled
+func (status *CompilerStatus) position(line string, offset int) asm.Pos {
+	if status.file == "" {
+		return asm.Pos{}
+	}
+	return asm.Pos{File: status.file, Line: status.line, Column: utf8.RuneCountInString(line[:offset]) + 1}
+}
+
+// Set the position of an error to the position of the token being compiled
+func (status *CompilerStatus) locate(err error) error {
+	if status.file == "" || status.nesting != 1 {
+		return err
+	}
+	compilerError, ok := err.(*CompilerError)
+	if !ok {
+		compilerError = NewCompilerError(err.Error())
+	}
+	if compilerError.Line == 0 {
+		compilerError.Pos = status.context.Pos
+	}
+	return compilerError
+}
+
+// Return an error as a diagnostic, the errors without a position are reported in the source file
+func (status *CompilerStatus) diagnostic(err error) asm.Diagnostic {
+	compilerError, ok := err.(*CompilerError)
+	if !ok {
+		compilerError = NewCompilerError(err.Error())
+	}
+	if compilerError.File == "" {
+		compilerError.File = status.file
+	}
+	return compilerError
+}
+
+// Abandon the definition and the control structures being compiled, after an error
+func (status *CompilerStatus) abandon() {
+	status.context.Reset()
+	status.interpreting = false
+	status.recording = false
+	status.replacement = nil
+	status.current = ""
+	status.currentLabel = ""
+	status.locals = nil
+}
+
 // Execute a compilation pass
 func CompilePass(input *os.File, output *os.File, pass Pass, labels map[string]bool, constants map[string]int) (*CompilerStatus, error) {
-	status := NewCompilerStatus(pass, output, labels, constants)
+	return compilePass(NewCompiler().words(), input, output, pass, labels, constants)
+}
+
+// Execute a compilation pass with a set of standard words
+// The errors in the program are collected and returned as an asm.ErrorList at the end of the pass
+func compilePass(standard *wordSet, input *os.File, output *os.File, pass Pass, labels map[string]bool, constants map[string]int) (*CompilerStatus, error) {
+	status := newCompilerStatus(standard, pass, output, labels, constants)
+	status.file = input.Name()
+	defer status.closeEvaluator()
+	var errs asm.ErrorList
 	scanner := bufio.NewScanner(input)
 	if status.pass == Second {
 		status.output.WriteString("start:\n")
 	}
-	for scanner.Scan() {
+	for scanner.Scan() && len(errs) < maxErrors {
 		line := scanner.Text()
+		status.line++
 		if len(line) == 0 {
 			continue
 		}
 		if err := CompileLine(status, line); err != nil {
-			return nil, err
+			errs.Add(status.diagnostic(err))
+			status.abandon()
+			continue
 		}
 		if status.pass == Second {
 			status.output.WriteString("\n")
 		}
 	}
-	if status.pass == Second {
-		status.output.WriteString(status.buf.String())
-	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	// Report the definitions and the control structures not terminated at the end of the file
+	for _, err := range status.context.Unterminated() {
+		errs.Add(err)
+	}
+	if len(errs) != 0 {
+		return status, errs
+	}
+	status.flushLiterals()
+	if status.pass == Second {
+		// The program ends before the definitions
+		status.output.WriteString("  hlt\n")
+	}
+	if err := status.compileLibrary(); err != nil {
+		return status, asm.ErrorList{status.diagnostic(err)}
+	}
+	if status.pass == Second {
+		status.output.WriteString(status.buf.String())
+		status.writeData()
+	}
 	return status, nil
 }
 
-// Compile a program file and return the compiled code
+// Compile a program file with the standard words and return the compiled code
 func Compile(filename string, outputFilename string) error {
+	return NewCompiler().Compile(filename, outputFilename)
+}
+
+// Compile a program file and return the compiled code
+func (compiler *Compiler) Compile(filename string, outputFilename string) error {
+	return compileFile(compiler.words(), filename, outputFilename)
+}
+
+func compileFile(standard *wordSet, filename string, outputFilename string) error {
 	input, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -425,13 +1420,24 @@ func Compile(filename string, outputFilename string) error {
 
 	// First pass
 	var status *CompilerStatus
-	if status, err = CompilePass(input, output, First, nil, nil); err != nil {
+	var errs asm.ErrorList
+	if status, err = compilePass(standard, input, output, First, nil, nil); err != nil && !errors.As(err, &errs) {
 		return err
 	}
-	// Second pass
+	// Second pass, executed even if the first one failed in order to report all the errors
 	input.Seek(0, 0) // rewind
-	if status, err = CompilePass(input, output, Second, status.labels, status.constants); err != nil {
-		return err
+	if _, err = compilePass(standard, input, output, Second, status.labels, status.constants); err != nil {
+		var secondPassErrs asm.ErrorList
+		if !errors.As(err, &secondPassErrs) {
+			return err
+		}
+		for _, e := range secondPassErrs {
+			errs.Add(e)
+		}
+	}
+	if len(errs) != 0 {
+		errs.Sort()
+		return errs
 	}
 	return nil
 }
@@ -5,15 +5,33 @@ import (
 	"fmt"
 	asm "github.com/andreax79/go-fcpu/pkg/assembler"
 	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var Halt = new(fcpu.Halt)
 
 func runForth(source string) (*fcpu.CPU, error) {
+	return runForthWithOutput(source, os.Stdout)
+}
+
+func runForthWithOutput(source string, output io.Writer) (*fcpu.CPU, error) {
+	return runForthWithCompiler(NewCompiler(), source, output)
+}
+
+func runForthWithCompiler(compiler *Compiler, source string, output io.Writer) (*fcpu.CPU, error) {
+	return runForthProgram(compiler, source+" hlt", output)
+}
+
+// Compile and run a program, without adding HLT at the end
+func runForthProgram(compiler *Compiler, source string, output io.Writer) (*fcpu.CPU, error) {
 	var err error
 	var tmpDir string
 	var forthFilename string
@@ -29,12 +47,12 @@ func runForth(source string) (*fcpu.CPU, error) {
 	defer os.RemoveAll(tmpDir) // clean up
 	forthFilename = filepath.Join(tmpDir, "source.ft")
 	// Write forth source file
-	if err = os.WriteFile(forthFilename, []byte(source+" hlt"), 0666); err != nil {
+	if err = os.WriteFile(forthFilename, []byte(source), 0666); err != nil {
 		return nil, err
 	}
 	// Forth => Asm
 	asmFilename = fmt.Sprintf("%s.pal", forthFilename)
-	err = Compile(forthFilename, asmFilename)
+	err = compiler.Compile(forthFilename, asmFilename)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +67,8 @@ func runForth(source string) (*fcpu.CPU, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer cpu.Close()
+	cpu.Output = output
 	for {
 		err := cpu.Eval()
 		if err != nil {
@@ -78,6 +98,22 @@ func testForth(t *testing.T, source string, compareSource string) {
 	}
 }
 
+func testForthOutput(t *testing.T, source string, expected string) {
+	var output strings.Builder
+	if _, err := runForthWithOutput(source, &output); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if output.String() != expected {
+		t.Fatalf("Wrong output: %q\nexpected:\n%q\n%s", output.String(), expected, source)
+	}
+}
+
+func testForthError(t *testing.T, source string, message string) {
+	if _, err := runForth(source); err == nil || !strings.Contains(err.Error(), message) {
+		t.Fatalf("Error %q expected, got %v\n%s", message, err, source)
+	}
+}
+
 func Test2Over(t *testing.T) {
 	testForth(t,
 		"1 2 3 4 2over",
@@ -255,3 +291,659 @@ func TestMemory(t *testing.T) {
 		"5 999 1000 100 0 0 100",
 	)
 }
+
+func TestVariable(t *testing.T) {
+	testForth(t, `
+        variable x
+        variable my-var
+        42 x !
+        x @
+        my-var @
+        7 my-var +! my-var @
+        x my-var <>
+        `,
+		"42 0 7 true",
+	)
+}
+
+func TestCreate(t *testing.T) {
+	testForth(t, `
+        create table 1 , 2 , 3 ,
+        create bytes 10 c, 20 c, 30 c,
+        create buf 10 cells allot
+        variable last
+        table @ table cell+ @ table 2 cells + @
+        bytes c@ bytes 2 + c@
+        buf 10 cells + last =
+        `,
+		"1 2 3 10 30 true",
+	)
+}
+
+func TestHere(t *testing.T) {
+	testForth(t, `
+        here 1 , here swap -
+        create a 100 allot
+        here a -
+        : reserve ( n -- addr ) here swap allot ;
+        : store ( x -- ) , ;
+        : current ( -- addr ) here ;
+        5 reserve current swap -
+        current 99 store @
+        `,
+		"4 100 5 99",
+	)
+}
+
+func TestCompileTimeRedefinitions(t *testing.T) {
+	// The words redefined by the program are not executed at compile time
+	testForth(t, ": 1+ 10 + ; 5 1+", "15")
+	testForth(t, ": + * ; 2 3 +", "6")
+	testForth(t, ": cells 3 * ; 2 cells", "6")
+	testForth(t, "3 constant cell+ 1 cell+", "1 3")
+	testForth(t, ": here 7 ; here", "7")
+	testForth(t, "variable v : , v ! ; 5 , v @", "5")
+}
+
+func TestDoes(t *testing.T) {
+	testForth(t, `
+        : array ( n -- ) create cells allot does> ( i addr -- addr' ) swap cells + ;
+        : const ( x -- ) create , does> @ ;
+        : buffer: ( n -- ) create allot ;
+        10 array foo
+        5 array bar
+        42 3 foo !
+        99 0 bar !
+        3 foo @  0 bar @  4 foo 3 foo -
+        7 const seven
+        16 buffer: buf
+        seven  buf 0 bar <>  buf 0 foo <>
+        : make-const ( -- ) 123 const ;
+        make-const onetwothree
+        : use-it ( -- x ) onetwothree 1+ ;
+        make-const onetwothree use-it
+        `,
+		"42 99 4 7 true true 124",
+	)
+}
+
+func TestStrings(t *testing.T) {
+	testForthOutput(t, `." Hello world!" cr`, "Hello world!\n")
+	testForthOutput(t, `: hello ( -- ) ." Hello, " type ." !" ; s" Forth" hello`, "Hello, Forth!")
+	testForthOutput(t, `c" counted" count type space s" " type ." back\slash" 2 spaces`, "counted back\\slash  ")
+	testForthOutput(t, `.( compile time) ." ( not a comment )"`, "( not a comment )")
+	testForth(t, `s" abc" swap drop  c" xy" c@  s" abc" drop 1+ c@`, "3 2 98")
+	testForth(t, `: check ( n -- n ) dup 0< abort" negative" ; 5 check`, "5")
+	testForthOutput(t, `: check ( n -- ) 0< abort" negative" ." positive " ; 5 check -1 check ." unreachable"`, "positive negative")
+}
+
+func TestNumericOutput(t *testing.T) {
+	testForthOutput(t, `0 . 123 . -45 . 1 2 3 .s`, "0 123 -45 <3> 1 2 3 ")
+	testForthOutput(t, `-1 u. hex ff . ff u. -a . 10 . decimal 255 .`, "4294967295 FF FF -A 10 255 ")
+	testForthOutput(t, `2 base ! 5 . decimal 42 5 .r 42 1 .r -7 4 .r 7 3 u.r`, "101    4242  -7  7")
+	testForthOutput(t, `: .hex ( n -- ) base @ swap hex . base ! ; 255 .hex 10 .`, "FF 10 ")
+	testForthOutput(t, `-1 2147483647 and u. -2147483648 .`, "2147483647 -2147483648 ")
+	testForthOutput(t, `12 0 <# # # 58 hold # #> type  -5 dup abs 0 <# #s rot sign #> type`, "0:12-5")
+	testForth(t, `1 2 .s 123 .`, "1 2")
+	// The library words are not affected by the words of the program
+	testForthOutput(t, `32 constant space 1 . 0 .`, "1 0 ")
+	testForthOutput(t, `: hold drop ; : space ; : sign ; immediate -12 . 3 .`, "-12 3 ")
+}
+
+func TestPlusLoop(t *testing.T) {
+	testForth(t, "10 0 do i 3 +loop", "0 3 6 9")
+	testForth(t, "0 10 do i -3 +loop", "10 7 4 1")
+	testForth(t, "0 4 do i -1 +loop", "4 3 2 1 0")
+	testForth(t, "6 0 do i 2 +loop", "0 2 4")
+	testForth(t, "-2147483647 2147483646 do i 1 +loop", "2147483646 2147483647 -2147483648")
+}
+
+func TestNestedLoops(t *testing.T) {
+	testForth(t, "3 1 do 12 10 do j i loop loop", "1 10 1 11 2 10 2 11")
+	testForth(t, "2 0 do 4 3 do 6 5 do k j i loop loop loop", "0 3 5 1 3 5")
+	testForth(t, "3 0 do 3 0 do i j = if leave then i loop loop", "0 0 1")
+}
+
+func TestQuestionDo(t *testing.T) {
+	testForth(t, "5 5 ?do i loop 7", "7")
+	testForth(t, "7 5 ?do i loop", "5 6")
+	testForth(t, ": count-down ( n -- ) 0 ?do i loop ; 0 count-down 3 count-down", "0 1 2")
+}
+
+func TestExit(t *testing.T) {
+	testForth(t, `
+        : sign-of ( n -- -1|0|1 ) dup 0< if drop -1 exit then 0> if 1 exit then 0 ;
+        -5 sign-of 0 sign-of 8 sign-of
+        : find-first ( limit -- i ) 0 do i i * 20 > if i unloop exit then loop -1 ;
+        10 find-first 3 find-first
+        `,
+		"-1 0 1 5 -1",
+	)
+}
+
+func TestAgain(t *testing.T) {
+	testForth(t, ": count-to ( n -- 0 .. n-1 ) >r 0 begin dup r@ = if drop r> drop exit then dup 1+ again ; 3 count-to", "0 1 2")
+}
+
+func TestRecurse(t *testing.T) {
+	testForth(t, `
+        : factorial ( n -- n! ) dup 1 > if dup 1- recurse * then ;
+        : fib ( n -- fib ) dup 2 < if exit then dup 1- recurse swap 2 - recurse + ;
+        5 factorial 10 fib
+        `,
+		"120 55",
+	)
+}
+
+func TestCase(t *testing.T) {
+	testForth(t, `
+        : classify ( n -- x )
+          case
+            1 of 100 endof
+            2 of 200 endof
+            dup 10 > if 1000 else 0 then swap
+          endcase ;
+        1 classify 2 classify 3 classify 42 classify
+        : nested ( a b -- x )
+          swap case
+            0 of case 0 of 1 endof 1 of 2 endof 0 swap endcase endof
+            1 of 15 swap ?do i 12 = if i leave then loop endof
+            nip 99 swap
+          endcase ;
+        0 0 nested 0 1 nested 0 5 nested 1 0 nested 2 0 nested
+        `,
+		"100 200 0 1000 1 2 0 12 99",
+	)
+}
+
+func TestCaseErrors(t *testing.T) {
+	for _, source := range []string{
+		"1 of 2 endof",
+		"1 case 1 endof endcase",
+		"1 case 1 of 2 endcase",
+		"1 case 1 of if endof then endcase",
+		": x case 1 of endof then ;",
+	} {
+		testForthError(t, source, "Unbalanced control structure")
+	}
+}
+
+func TestInterpretationState(t *testing.T) {
+	testForth(t, `
+        : six ( -- n ) [ 2 3 * ] literal ;
+        : square ( n -- n*n ) dup * ;
+        : lookup ( -- n ) [ 12 square 1+ ] literal ;
+        : two ( -- a b ) [ 1 2 ] literal literal ;
+        : chars ( -- c1 c2 ) [char] A [char] zebra ;
+        six lookup two chars
+        `,
+		"6 145 2 1 65 122",
+	)
+	testForth(t, `
+        100 constant hundred
+        hex
+        : mask ( -- n ) [ ff hundred + ] literal ;
+        decimal
+        mask $10 #-10 %101
+        `,
+		"355 16 -10 5",
+	)
+}
+
+func TestImmediate(t *testing.T) {
+	testForth(t, `
+        : unless ( -- ) postpone 0= postpone if ; immediate
+        : classify ( n -- x ) 0< unless 1 else -1 then ;
+        : five ( -- ) 5 postpone literal ; immediate
+        : add-five ( n -- n+5 ) five + ;
+        : twice ( -- ) postpone dup postpone + ; immediate
+        : quad ( n -- 4n ) twice twice ;
+        : twenty ( -- ) 5 quad postpone literal ; immediate
+        : uses-all ( -- n ) twenty [ 3 ] literal add-five ;
+        5 classify -5 classify 10 add-five 3 quad uses-all
+        `,
+		"1 -1 15 12 20 8",
+	)
+}
+
+func TestTick(t *testing.T) {
+	testForth(t, `
+        : square ( n -- n*n ) dup * ;
+        variable x
+        : apply ( n xt -- n' ) execute ;
+        : use-ticks ( -- ) 3 ['] square apply  5 ['] 1+ apply  ['] x execute x = ;
+        use-ticks
+        2 ['] negate execute
+        `,
+		"9 6 true -2",
+	)
+}
+
+func TestImmediateErrors(t *testing.T) {
+	testForthError(t, "1 ] 2", "]: [ expected")
+	testForthError(t, "[ 1 ]", "[: not allowed outside a definition")
+	testForthError(t, ": x literal ;", "literal: value known at compile time expected")
+	testForthError(t, ": x [ 1 0 do again ] ;", "Unbalanced control structure")
+	testForthError(t, ": x [ begin again ] ;", "instructions limit exceeded")
+	testForthError(t, "immediate", "immediate: definition expected")
+	testForthError(t, ": x [ unknown-word ] ;", "unknown-word ?")
+	testForthError(t, ": x ['] unknown-word ;", "unknown-word ?")
+	// The addresses of the compile-time machine are not valid in the program
+	testForthError(t, "variable vv : t8 [ vv ] literal ; t8 vv =", "address not available at run time")
+	testForthError(t, "create tbl 2 cells allot : t [ tbl cell+ ] literal ;", "address not available at run time")
+	testForthError(t, ": x [ ' dup ] literal ;", "address not available at run time")
+}
+
+func TestEvaluatorClose(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "source.ft")
+	if err := os.WriteFile(filename, []byte(": t [ 1 2 + ] literal ;"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	goroutines := runtime.NumGoroutine()
+	if err := Compile(filename, filename+".pal"); err != nil {
+		t.Fatal(err)
+	}
+	// The machines executing the code at compile time are released
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines expected after the compilation, got %d", goroutines, n)
+	}
+}
+
+func TestCompiler(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.AddDefinitions(map[string]string{"triple": "dup dup + +"})
+	compiler.AddConstants(map[string]int{"answer": 42})
+	compiler.AddLibrary(map[string]string{"cube": "dup dup * *"})
+	cpu, err := runForthWithCompiler(compiler, "2 triple answer 3 cube", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stack := cpu.Ds.Array(); !reflect.DeepEqual(stack, []fcpu.Word{6, 42, 27}) {
+		t.Errorf("Expected [6 42 27], got %v", stack)
+	}
+	// The extra words are not added to the standard words
+	if _, err = runForth("2 triple"); err == nil {
+		t.Errorf("triple: error expected")
+	}
+	// The definitions of a program are not visible to the following compilations
+	if _, err = runForthWithCompiler(compiler, ": sq dup * ; 1 constant one", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"2 sq", "one"} {
+		if _, err = runForthWithCompiler(compiler, source, io.Discard); err == nil {
+			t.Errorf("%s: error expected", source)
+		}
+	}
+}
+
+func TestCompilerConcurrency(t *testing.T) {
+	compiler := NewCompiler()
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			// Every program redefines the same word
+			source := fmt.Sprintf(": w %d ; : t [ w 1+ ] literal ; w t", n)
+			cpu, err := runForthWithCompiler(compiler, source, io.Discard)
+			if err == nil && !reflect.DeepEqual(cpu.Ds.Array(), []fcpu.Word{fcpu.Word(n), fcpu.Word(n + 1)}) {
+				err = fmt.Errorf("%s: got %v", source, cpu.Ds.Array())
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tmpDir := t.TempDir()
+	forthFilename := filepath.Join(tmpDir, "source.ft")
+	source := `: a 1 else ;
+1 foo
+
+: b begin 1 if
+  ( comment`
+	if err := os.WriteFile(forthFilename, []byte(source), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err := Compile(forthFilename, forthFilename+".pal")
+	var errs asm.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("error list expected, got: %v", err)
+	}
+	expected := []struct {
+		Line    int
+		Column  int
+		Message string
+	}{
+		{1, 7, "Unbalanced control structure 'else'"},
+		{2, 3, "foo ?"},
+		{4, 1, "Unterminated control structure ':', ';' expected"},
+		{4, 5, "Unterminated control structure 'begin', 'until', 'again' or 'repeat' expected"},
+		{4, 13, "Unterminated control structure 'if', 'then' expected"},
+		{5, 3, "Unterminated control structure '(', ')' expected"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), errs)
+	}
+	for i, e := range expected {
+		pos := errs[i].Position()
+		if pos.File != forthFilename || pos.Line != e.Line || pos.Column != e.Column || !strings.Contains(errs[i].Error(), e.Message) {
+			t.Errorf("expected %d:%d %q, got: %s %s", e.Line, e.Column, e.Message, pos, errs[i])
+		}
+	}
+}
+
+func TestCatch(t *testing.T) {
+	testForth(t, `
+        : t1 1 2 ;
+        : t2 1 2 3 42 throw ;
+        : t3 ['] t2 catch 1+ ;
+        : t4 0 throw 5 ;
+        7 ['] t1 catch
+        8 ['] t2 catch
+        ['] t3 catch
+        ['] t4 catch
+        `,
+		"7 1 2 0 8 42 43 0 5 0",
+	)
+	// The depth of the data stack is restored
+	testForth(t, `: t drop drop drop 9 throw ; 1 2 3 ['] t catch depth >r 2drop 2drop r>`, "4")
+	testForth(t, `: t 1 2 3 9 throw ; 1 ['] t catch`, "1 9")
+	// CPU traps
+	testForth(t, `
+        : t1 5 0 / ;
+        : t2 5 0 mod ;
+        : t3 -1 @ ;
+        ['] t1 catch ['] t2 catch ['] t3 catch
+        `,
+		"-10 -10 -9",
+	)
+	testForth(t, `: t 7 (trap) ; ['] t catch`, "-263")
+	// ABORT and ABORT"
+	testForth(t, `: t1 abort ; : t2 0 abort" no" 1 abort" yes" ; ['] t1 catch ['] t2 catch`, "-1 -2")
+}
+
+func TestUncaught(t *testing.T) {
+	testForthOutput(t, `: t 1 abort" message" ; 1 2 t 3 emit`, "message")
+	testForthOutput(t, `: t 2 throw ; ['] t catch drop 65 emit 3 throw 66 emit`, "AError 3 ")
+	cpu, err := runForth("1 2 abort 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu.Ds.Size() != 0 {
+		t.Errorf("Empty data stack expected, got %v", cpu.Ds.Array())
+	}
+	// The traps not caught stop the machine, even after a CATCH
+	for _, source := range []string{": t 0 / ; 1 0 t", ": t 0 / ; 1 ['] dup catch drop 2drop 1 0 t", ": t 0 / ; : c ['] t catch ; 1 0 c drop 1 0 t"} {
+		err = NewRepl(strings.NewReader(""), io.Discard).Execute(source)
+		var trap *fcpu.Trap
+		if !errors.As(err, &trap) || trap.Code != fcpu.DivisionByZero {
+			t.Errorf("Division by zero trap expected, got %v\n%s", err, source)
+		}
+	}
+}
+
+func TestProgramEnd(t *testing.T) {
+	// The program stops before the first definition
+	var output strings.Builder
+	cpu, err := runForthProgram(NewCompiler(), ": t 1 throw ; 2", &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "" || !reflect.DeepEqual(cpu.Ds.Array(), []fcpu.Word{2}) {
+		t.Errorf("Expected no output and [2], got %q %v", output.String(), cpu.Ds.Array())
+	}
+}
+
+func TestLocals(t *testing.T) {
+	testForth(t, `
+        : swap2 {: a b -- b a :} b a ;
+        : sum3 {: a b c | t -- n :} a b + to t t c + ;
+        : fact {: n -- n! :} n 1 > if n n 1- recurse * exit then 1 ;
+        : loop-sum {: n | acc :} n 0 do i acc + to acc loop acc ;
+        : leave-loop {: x :} 10 0 do i 5 = if x unloop exit then loop 0 ;
+        : shadow {: dup :} dup dup ;
+        1 2 swap2 1 2 3 sum3 5 fact 5 loop-sum 7 leave-loop 4 shadow
+        `,
+		"2 1 6 120 10 7 4 4",
+	)
+	// The frame is removed by THROW
+	testForth(t, `: t {: x :} x 9 throw ; : u {: y :} 3 ['] t catch nip y ; 5 u`, "9 5")
+}
+
+func TestLocalsErrors(t *testing.T) {
+	testForthError(t, "{: a :}", "{:: not allowed outside a definition")
+	testForthError(t, ": t {: a b ;", "{:: missing :}")
+	testForthError(t, ": t {: a :} {: b :} ;", "{:: locals already declared")
+	testForthError(t, ": t 1 if {: a :} then ;", "{:: not allowed outside a definition")
+	testForthError(t, ": t {: a :} 1 to b ;", "to: b is not a value")
+	testForthError(t, ": t {: a :} ; : u 1 to a ;", "to: a is not a value")
+}
+
+func TestValue(t *testing.T) {
+	testForth(t, `
+        42 value x
+        variable v
+        v value addr
+        x 7 to x x
+        : bump x 1+ to x ; bump bump x
+        : t {: x :} 5 to x x ; 1 t x
+        13 addr ! v @
+        `,
+		"42 7 9 5 9 13",
+	)
+}
+
+func TestDefer(t *testing.T) {
+	testForth(t, `
+        defer action
+        : one 1 ; : two 2 ;
+        ' one is action action
+        : set-two ['] two is action ; set-two action
+        action-of action ['] two =
+        : run action action ; ' one is action run
+        `,
+		"1 2 -1 1 1",
+	)
+	testForthOutput(t, `defer custom-emit ' emit is custom-emit 65 custom-emit defer none none 66 emit`, "Adeferred word not set")
+	testForth(t, `defer none : t ['] none catch ; t`, "-2")
+}
+
+func TestTickInterpreted(t *testing.T) {
+	testForth(t, `
+        : sq dup * ;
+        3 ' sq execute
+        create table ' sq , ' 1+ ,
+        4 table @ execute 4 table cell+ @ execute
+        `,
+		"9 16 5",
+	)
+}
+
+func TestValueErrors(t *testing.T) {
+	testForthError(t, "value x", "value: value known at compile time expected")
+	testForthError(t, ": t 1 value x ;", "value: not allowed in a definition")
+	testForthError(t, ": t defer x ;", "defer: not allowed in a definition")
+	testForthError(t, "variable v 1 to v", "to: v is not a value")
+	testForthError(t, "1 value x ' x is x", "is: x is not a deferred word")
+	testForthError(t, "action-of dup", "action-of: dup is not a deferred word")
+	testForthError(t, ": t ' dup ;", "': not allowed in a definition, use [']")
+	testForthError(t, "' unknown-word execute", "unknown-word ?")
+}
+
+func TestDouble(t *testing.T) {
+	testForth(t, `
+        100000 100000 um* -2 3 m*
+        10. 3 um/mod -7. 2 sm/rem -7. 2 fm/mod 7. -2 fm/mod
+        1000000 3000 1000 */ 7 3 5 */mod
+        4294967295. 1. d+ 0. 1. d-
+        -5. dabs d0= 0. d0= -1. d0< 1. 1. d=
+        `,
+		"1410065408 2 -6 -1 1 3 -1 -3 1 -4 -1 -4 3000000 1 4 0 1 -1 -1 0 -1 -1 -1",
+	)
+	testForth(t, `
+        123. 2constant big
+        2variable dv
+        big dv 2! dv 2@
+        : t 42. big d+ ; t
+        `,
+		"123 0 165 0",
+	)
+	testForthOutput(t, `1. d. -1. d. 12345678901. d. -2147483648 -1 m* d. hex ff. d. decimal`, "1 -1 12345678901 2147483648 FF ")
+	testForth(t, `: t 1. 0 um/mod ; ' t catch`, "-10")
+}
+
+func TestUnsigned(t *testing.T) {
+	testForth(t, `
+        -1 1 u< -1 1 u> 1 -1 u< 5 5 u<
+        -8 1 rshift -8 2/ 7 2/ 3 2*
+        -1 16 u/mod 7 2 u/mod
+        -1 0 2 um/mod
+        `,
+		"0 -1 -1 0 2147483644 -4 3 6 15 268435455 1 3 1 2147483647",
+	)
+	testForthOutput(t, `-1 u. 0 1 d.`, "4294967295 4294967296 ")
+}
+
+func TestFloat(t *testing.T) {
+	testForth(t, `
+        1e 2e f< 2e 1e f< 0e f0= -1e f0< 1.5e0 1.5E0 f=
+        7.9e0 f>s -7.9e0 f>s 1e10 f>d
+        -7 s>f f>s 10. d>f f>d
+        2.5e0 fround f>s 3.5e0 fround f>s -1.5e0 floor f>s -1.5e0 ftrunc f>s
+        1e 2e 3e fdepth frot f>s f>s f>s fdepth
+        3 floats 2 float+
+        `,
+		"-1 0 -1 -1 -1 7 -7 1410065408 2 -7 10 0 2 4 -2 -1 3 1 3 2 0 12 6",
+	)
+	testForthOutput(t, `1.5E0 f. 2e0 fsqrt f. 1e f. -2.5e-3 f. 3 s>f 4 s>f f/ f. 2e 10e f** f. 1e 0e f/ f.`, "1.5 1.4142135 1. -0.0025 0.75 1024. +Inf ")
+	testForthOutput(t, `
+        3.14159e0 fconstant pi
+        fvariable fv pi 2e f* fv f!
+        : area ( F: r -- r ) fdup f* pi f* ;
+        : root2 [ 2e fsqrt ] fliteral ;
+        fv f@ f. 2e area f. root2 f.
+        create tab 2 floats allot 1e tab f! 2e tab float+ f! tab f@ tab float+ f@ f+ f.
+        `,
+		"6.28318 12.56636 1.4142135 3. ",
+	)
+	// Floats are recognized only in decimal
+	testForth(t, `hex 1e decimal`, "30")
+}
+
+func TestFloatErrors(t *testing.T) {
+	testForthError(t, "fconstant x", "fconstant: floating-point value known at compile time expected")
+	testForthError(t, ": t 1e fconstant x ;", "fconstant: not allowed in a definition")
+	testForthError(t, ": t fvariable x ;", "fvariable: not allowed in a definition")
+	testForthError(t, ": t fliteral ;", "fliteral: floating-point value known at compile time expected")
+	testForthError(t, "1e fliteral", "fliteral: not allowed outside a definition")
+}
+
+func TestMemoryBlocks(t *testing.T) {
+	testForthOutput(t, `
+        create buf 16 allot
+        : show buf 8 type space ;
+        buf 16 97 fill show
+        s" hello" buf swap move show
+        buf buf 1+ 4 cmove show
+        s" abcdef" buf swap move buf buf 2 + 4 cmove> show
+        s" abcdef" buf swap move buf 2 + buf 4 move show
+        buf 4 blank show buf 8 erase 0 buf 8 + buf do i c@ + loop .
+        `,
+		"aaaaaaaa helloaaa hhhhhaaa ababcdaa cdefefaa     efaa 0 ",
+	)
+	testForth(t, `
+        s" abc" s" abc" compare s" abc" s" abd" compare s" abd" s" abc" compare
+        s" ab" s" abc" compare s" abc" s" ab" compare s" " s" " compare
+        `,
+		"0 -1 1 -1 1 0",
+	)
+	testForthOutput(t, `
+        s" hello world" s" wor" search . type space
+        s" hello" s" xyz" search . type space
+        s" hello" s" " search . type
+        `,
+		"-1 world 0 hello -1 hello",
+	)
+	testForth(t, `: t 0 1- here 4 move ; ' t catch`, "-9")
+}
+
+func TestAllocate(t *testing.T) {
+	testForth(t, `
+        variable a
+        100 allocate swap a !
+        50 allocate nip
+        42 a @ ! a @ 200 resize swap a ! a @ @
+        a @ free a @ free
+        -1 allocate nip
+        `,
+		"0 0 0 42 0 -60 -59",
+	)
+	testForthOutput(t, `s" hello" dup allocate drop swap 2dup 2>r move 2r> type`, "hello")
+	// The sizes larger than the heap are rejected, the block is not changed
+	testForth(t, `
+        variable b
+        100 allocate drop b !
+        b @ -1 resize swap b @ -
+        b @ -3 resize nip
+        8 allocate drop b @ <>
+        `,
+		"-61 0 -61 true",
+	)
+}
+
+func TestTasks(t *testing.T) {
+	testForthOutput(t, `
+        task t1 task t2 mailbox box 0 semaphore finished
+        : producer 5 0 do i 10 * box send loop ;
+        : consumer 5 0 do box receive . loop finished release ;
+        ' producer t1 activate ' consumer t2 activate
+        1 2 finished acquire ." done " . .
+        : counter 3 0 do ." c" i . pause loop ;
+        ' counter t1 activate
+        : wait 6 0 do pause loop ; wait ." end"
+        `,
+		"0 10 20 30 40 done 2 1 c0 c1 c2 end",
+	)
+	// Each task has its own exception frames
+	testForthOutput(t, `
+        task t1
+        : fail pause 7 throw ;
+        : safe ['] fail catch ." caught " . ;
+        ' safe t1 activate
+        : inner pause pause 5 ;
+        ['] inner catch . .
+        `,
+		"caught 7 0 5 ",
+	)
+	// Activating a running task restarts it
+	testForthOutput(t, `task t : w begin pause again ; ' w t activate ' w t activate pause ." back"`, "back")
+	testForthOutput(t, `
+        task t1 task t2
+        : w1 ." a" begin pause again ; : w2 ." b" begin pause again ;
+        ' w1 t1 activate ' w2 t2 activate ' w1 t2 activate
+        pause pause ." end"
+        `,
+		"aaend",
+	)
+	// A task stopping the program when it is the last one
+	testForthOutput(t, `: t ." stop" stop ." not reached" ; t`, "stop")
+}
+
+func TestTaskErrors(t *testing.T) {
+	testForthError(t, ": t task x ;", "task: not allowed in a definition")
+	testForthError(t, ": t mailbox x ;", "mailbox: not allowed in a definition")
+	testForthError(t, "semaphore x", "semaphore: value known at compile time expected")
+	testForthError(t, ": t 1 semaphore x ;", "semaphore: not allowed in a definition")
+}
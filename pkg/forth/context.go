@@ -1,5 +1,11 @@
 package forth
 
+import (
+	"fmt"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	"strings"
+)
+
 type Statement uint8
 
 const (
@@ -11,17 +17,36 @@ const (
 	Colon
 	Code
 	Paren // Parenthesis comments
+	Case
+	Of
 )
 
+// Words closing the statements, for the error messages
+var closingWords = map[Statement]string{
+	If:    "'then'",
+	Else:  "'then'",
+	Do:    "'loop' or '+loop'",
+	Begin: "'until', 'again' or 'repeat'",
+	Colon: "';'",
+	Code:  "';'",
+	Paren: "')'",
+	Case:  "'endcase'",
+	Of:    "'endof'",
+}
+
 type ContextStack struct {
-	node *Node
-	ids  map[Statement]int
+	node  *Node
+	ids   map[Statement]int
+	Token string  // token being compiled, recorded by Enter as the opening token of the statement
+	Pos   asm.Pos // position of the token being compiled
 }
 
 type Node struct {
 	statement Statement
 	id        int
 	next      *Node
+	token     string  // opening token
+	pos       asm.Pos // position of the opening token
 }
 
 // Enter a new context
@@ -43,6 +68,8 @@ func (s *ContextStack) Enter(statement Statement) {
 		statement: statement,
 		id:        id,
 		next:      s.node,
+		token:     s.Token,
+		pos:       s.Pos,
 	}
 }
 
@@ -76,6 +103,43 @@ func (s *ContextStack) HasAnchestor(statement Statement) bool {
 	return false
 }
 
+// Get the id of the nearest context (current or anchestor) with the given statement, 0 if not found
+func (s *ContextStack) AnchestorId(statement Statement) int {
+	for node := s.node; node != nil; node = node.next {
+		if node.statement == statement {
+			return node.id
+		}
+	}
+	return 0
+}
+
+// Count the current and anchestor contexts with the given statement
+func (s *ContextStack) Count(statement Statement) int {
+	count := 0
+	for node := s.node; node != nil; node = node.next {
+		if node.statement == statement {
+			count++
+		}
+	}
+	return count
+}
+
+// Return an error for each statement not terminated (the current and the anchestor contexts)
+func (s *ContextStack) Unterminated() []*CompilerError {
+	var errs []*CompilerError
+	for node := s.node; node != nil; node = node.next {
+		err := NewCompilerError(fmt.Sprintf("Unterminated control structure '%s', %s expected", strings.ToLower(node.token), closingWords[node.statement]))
+		err.Pos = node.pos
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Leave all the contexts
+func (s *ContextStack) Reset() {
+	s.node = nil
+}
+
 // Leave the current context
 func (s *ContextStack) Exit() {
 	if s.node != nil {
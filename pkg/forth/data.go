@@ -0,0 +1,331 @@
+package forth
+
+import (
+	"fmt"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"strconv"
+	"strings"
+)
+
+// Data space
+//
+// Outside colon definitions the numbers, the constants and the addresses known
+// at compile time are not compiled immediately, but they are kept on a
+// compile-time stack of literals. In this way ALLOT , C, ALIGN CONSTANT and
+// HERE are executed at compile time, reserving space in the data segment of
+// the generated assembly. The literals are compiled when a word needs them
+// at run time.
+//
+// Inside colon definitions the data space words are compiled and they use the
+// run time data space pointer, that starts at the end of the compile-time data.
+
+// Label of the cell containing the run time data space pointer
+const herePointer = "here_ptr"
+
+// Label of the cell containing the address of the body cell of the word defined by CREATE at run time
+const createPointer = "create_ptr"
+
+// Label of the cell containing the current number-conversion radix
+const numberBase = "number_base"
+
+// Labels of the cell containing the pointer to the pictured numeric output string and of the end of the string buffer
+const holdPointer = "hold_ptr"
+const holdEnd = "hold_end"
+
+// Size of the pictured numeric output buffer (a double number in binary with sign, aligned)
+const holdSize = 2*8*int(fcpu.WordSize) + int(fcpu.WordSize)
+
+// Label of the cell containing the return stack pointer of the innermost CATCH frame (0 outside CATCH)
+const catchHandler = "catch_handler"
+
+// Label of the cell pair containing the message of the last ABORT" (c-addr u)
+const abortMessage = "abort_msg"
+
+// Label of the end of the compile-time data
+const dataEnd = "data_end"
+
+// Defining words reserving data space, map the names to the size of the space
+var dataWords = map[string]int{
+	"VARIABLE":  int(fcpu.WordSize),
+	"2VARIABLE": 2 * int(fcpu.WordSize),
+	"FVARIABLE": int(fcpu.WordSize),
+	"MAILBOX":   2 * int(fcpu.WordSize), // message and full flag
+	"TASK":      taskReturnBase,         // control block, data stack and return stack
+}
+
+// Words executed at compile time when the operand is a number
+var unaryFolds = map[string]func(int) int{
+	"CELLS":   func(n int) int { return n * int(fcpu.WordSize) },
+	"CHARS":   func(n int) int { return n },
+	"CELL+":   func(n int) int { return n + int(fcpu.WordSize) },
+	"FLOATS":  func(n int) int { return n * int(fcpu.WordSize) },
+	"FLOAT+":  func(n int) int { return n + int(fcpu.WordSize) },
+	"CHAR+":   func(n int) int { return n + 1 },
+	"ALIGNED": func(n int) int { return aligned(n) },
+	"NEGATE":  func(n int) int { return -n },
+	"1+":      func(n int) int { return n + 1 },
+	"1-":      func(n int) int { return n - 1 },
+}
+
+// Words executed at compile time when the operands are numbers
+var binaryFolds = map[string]func(int, int) int{
+	"+": func(n1 int, n2 int) int { return n1 + n2 },
+	"-": func(n1 int, n2 int) int { return n1 - n2 },
+	"*": func(n1 int, n2 int) int { return n1 * n2 },
+}
+
+// Round up to the cell size
+func aligned(n int) int {
+	return (n + int(fcpu.WordSize) - 1) &^ (int(fcpu.WordSize) - 1)
+}
+
+// Return a label for a word, the chars not allowed in the assembler labels are escaped
+func wordLabel(name string, suffix string) string {
+	var buf strings.Builder
+	for i, ch := range strings.ToLower(name) {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch == '_':
+			buf.WriteRune(ch)
+		case ch >= '0' && ch <= '9':
+			if i == 0 {
+				buf.WriteRune('$') // labels can't start with a digit
+			}
+			buf.WriteRune(ch)
+		default:
+			fmt.Fprintf(&buf, "$%02x", ch)
+		}
+	}
+	return buf.String() + "_" + suffix
+}
+
+// Return a new label for a word, unique in the compilation pass (words can be redefined)
+func (status *CompilerStatus) newLabel(name string, suffix string) string {
+	label := wordLabel(name, suffix)
+	status.used[label]++
+	if n := status.used[label]; n > 1 {
+		label = fmt.Sprintf("%s%d", label, n)
+	}
+	return label
+}
+
+// Check if the compiler is inside a colon definition
+func (status *CompilerStatus) inDefinition() bool {
+	return status.context.HasAnchestor(Colon)
+}
+
+// Push a literal (a number or a label) on the compile-time stack
+func (status *CompilerStatus) pushLiteral(value string) {
+	status.literals = append(status.literals, value)
+}
+
+// Push a number on the compile-time stack
+func (status *CompilerStatus) pushNumber(value int) {
+	status.pushLiteral(strconv.Itoa(value))
+}
+
+// Return the number of numbers on the top of the compile-time stack
+func (status *CompilerStatus) numbers() int {
+	n := 0
+	for i := len(status.literals) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(status.literals[i]); err != nil {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// Pop a literal from the compile-time stack
+func (status *CompilerStatus) popLiteral(word string) (string, error) {
+	if len(status.literals) == 0 {
+		return "", NewCompilerError(fmt.Sprintf("%s: value known at compile time expected", strings.ToLower(word)))
+	}
+	value := status.literals[len(status.literals)-1]
+	status.literals = status.literals[:len(status.literals)-1]
+	return value, nil
+}
+
+// Pop a number from the compile-time stack
+func (status *CompilerStatus) popNumber(word string) (int, error) {
+	value, err := status.popLiteral(word)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, NewCompilerError(fmt.Sprintf("%s: number expected", strings.ToLower(word)))
+	}
+	return n, nil
+}
+
+// Pop a float from the compile-time floating-point stack
+func (status *CompilerStatus) popFloat(word string) (float32, error) {
+	if len(status.floats) == 0 {
+		return 0, NewCompilerError(fmt.Sprintf("%s: floating-point value known at compile time expected", strings.ToLower(word)))
+	}
+	r := status.floats[len(status.floats)-1]
+	status.floats = status.floats[:len(status.floats)-1]
+	return r, nil
+}
+
+// Compile the literals on the compile-time stacks
+func (status *CompilerStatus) flushLiterals() {
+	for _, value := range status.literals {
+		if status.pass == Second {
+			status.WriteString(fmt.Sprintf("  push %s", value))
+		}
+	}
+	status.literals = status.literals[:0]
+	for _, r := range status.floats {
+		status.compileFloat(r)
+	}
+	status.floats = status.floats[:0]
+}
+
+// Add a line to the data segment
+func (status *CompilerStatus) addData(format string, a ...any) {
+	if status.pass == Second {
+		status.data.WriteString(fmt.Sprintf(format, a...) + "\n")
+	}
+}
+
+// Reserve space in the data segment
+func (status *CompilerStatus) allot(size int) {
+	if size > 0 {
+		status.addData("  .space %d", size)
+	}
+	status.dataSize += size
+}
+
+// Align the data space
+func (status *CompilerStatus) align() {
+	status.allot(aligned(status.dataSize) - status.dataSize)
+}
+
+// Define a data label at the current position of the data space
+func (status *CompilerStatus) dataLabel(label string) {
+	status.labels[strings.ToUpper(label)] = true
+	status.addData("%s:", label)
+}
+
+// Reserve an aligned cell of data space initialized to value (a number or a label), return the label of the cell
+func (status *CompilerStatus) cell(name string, suffix string, value string) string {
+	status.align()
+	label := status.newLabel(name, suffix)
+	status.dataLabel(label)
+	status.addData("  .word %s", value)
+	status.dataSize += int(fcpu.WordSize)
+	return label
+}
+
+// Add a string to the data segment, return the label of the string
+func (status *CompilerStatus) stringLiteral(text string, counted bool) string {
+	status.stringId++
+	label := fmt.Sprintf("str_%d", status.stringId)
+	status.dataLabel(label)
+	if counted {
+		status.addData("  .byte %d", len(text))
+		status.dataSize++
+	}
+	if len(text) > 0 {
+		status.addData("  .ascii %s", asmString(text))
+	}
+	status.dataSize += len(text)
+	return label
+}
+
+// Compile a string literal, the address and the length of the string are pushed at run time
+func (status *CompilerStatus) compileString(text string) {
+	label := status.stringLiteral(text, false)
+	if status.pass == Second {
+		status.WriteString(fmt.Sprintf("  push %s push %d", label, len(text)))
+	}
+}
+
+// Return true if the program redefines a standard word (or defines a word without a standard definition)
+func (status *CompilerStatus) redefined(token string) bool {
+	if definition, exists := status.dictionary[token]; exists && definition != status.standard.definitions[token] {
+		return true
+	}
+	value, isConstant := status.constants[token]
+	standard, isStandard := status.standard.constants[token]
+	return isConstant && (!isStandard || value != standard)
+}
+
+// Try to execute a word at compile time, return true if the word has been executed.
+// The words redefined by the program are not executed at compile time.
+func (status *CompilerStatus) compileTime(token string) (bool, error) {
+	if status.inDefinition() || status.redefined(token) {
+		return false, nil
+	}
+	if fold, exists := unaryFolds[token]; exists && status.numbers() >= 1 {
+		n, _ := status.popNumber(token)
+		status.pushNumber(fold(n))
+		return true, nil
+	}
+	if fold, exists := binaryFolds[token]; exists && status.numbers() >= 2 {
+		n2, _ := status.popNumber(token)
+		n1, _ := status.popNumber(token)
+		status.pushNumber(fold(n1, n2))
+		return true, nil
+	}
+	switch token {
+	case "HERE": // ( -- addr ) Address of the next free data space location
+		status.hereId++
+		label := fmt.Sprintf("here_%d", status.hereId)
+		status.dataLabel(label)
+		status.pushLiteral(label)
+	case "ALLOT": // ( n -- ) Reserve n bytes of data space
+		n, err := status.popNumber(token)
+		if err != nil {
+			return true, err
+		}
+		if n < 0 {
+			return true, NewCompilerError("allot: negative size")
+		}
+		status.allot(n)
+	case "ALIGN", "FALIGN": // ( -- ) Align the data space pointer
+		status.align()
+	case ",": // ( x -- ) Reserve one cell of data space and store x in the cell
+		value, err := status.popLiteral(token)
+		if err != nil {
+			return true, err
+		}
+		status.addData("  .word %s", value)
+		status.dataSize += int(fcpu.WordSize)
+	case "C,": // ( char -- ) Reserve one byte of data space and store char in the byte
+		n, err := status.popNumber(token)
+		if err != nil {
+			return true, err
+		}
+		status.addData("  .byte %d", byte(n))
+		status.dataSize++
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Write the data segment
+func (status *CompilerStatus) writeData() {
+	status.align() // the run time data space starts aligned
+	status.output.WriteString("\n.data\n")
+	status.output.WriteString(fmt.Sprintf("%s: .word %s\n", herePointer, dataEnd))
+	status.output.WriteString(fmt.Sprintf("%s: .word 0\n", createPointer))
+	status.output.WriteString(fmt.Sprintf("%s: .word 10\n", numberBase))
+	status.output.WriteString(fmt.Sprintf("%s: .word %s\n", holdPointer, holdEnd))
+	status.output.WriteString(fmt.Sprintf("  .space %d\n%s:\n", holdSize, holdEnd))
+	status.writeCompileBuffer()
+	status.writeExceptionData()
+	status.writeTaskData()
+	status.output.WriteString(status.data.String())
+	status.output.WriteString(fmt.Sprintf("%s:\n.text\n", dataEnd))
+}
+
+// Write the exception handling variables, used by THROW and PAUSE
+func (status *CompilerStatus) writeExceptionData() {
+	if status.session || status.library["THROW"] || status.library["PAUSE"] {
+		status.output.WriteString(fmt.Sprintf("%s: .word 0\n", catchHandler))
+		status.output.WriteString(fmt.Sprintf("%s: .word 0 0\n", abortMessage))
+	}
+}
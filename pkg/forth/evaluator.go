@@ -0,0 +1,206 @@
+package forth
+
+import (
+	"fmt"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Compile-time evaluator
+//
+// The words between [ and ] and the immediate words are executed at compile
+// time by a separate machine, containing the definitions compiled before (the
+// prelude). The code starts with the compile-time stack of literals and, at
+// the end, its stack becomes the new compile-time stack.
+//
+// The words executed at compile time can append text to the definition being
+// compiled (using POSTPONE): the text is written in a buffer, and it is
+// compiled by the compiler when the execution terminates.
+//
+// The machine is kept between the executions and it is loaded as a REPL (see
+// Repl): the prelude is compiled and loaded once, a part at a time, and each
+// execution compiles and loads only the code to be executed. The data defined
+// at top level is not initialized and the changes to the data space are not
+// visible to the program. The machine is released at the end of the pass.
+//
+// The addresses of the code and of the data of the machine are not valid in
+// the program: the execution fails if one of them is left on the stack.
+
+// Labels of the cell containing the pointer to the compile buffer and of the compile buffer
+const compilePointer = "compile_ptr"
+const compileBuffer = "compile_buf"
+
+// Size of the compile buffer
+const compileBufferSize = 4096
+
+// Maximum number of instructions executed at compile time
+const evaluationLimit = 10000000
+
+// Set the text recorded instead of the source of the current token
+func (status *CompilerStatus) replace(text string) {
+	status.replacement = &text
+}
+
+// Record the source of a token, if inside a definition
+func (status *CompilerStatus) record(text string) {
+	if status.replacement != nil {
+		text = *status.replacement
+		status.replacement = nil
+	}
+	if !status.recording {
+		return
+	}
+	status.source.WriteString(text + " ")
+	if !status.inDefinition() {
+		// End of the definition
+		status.recording = false
+		status.prelude.WriteString(status.source.String() + "\n")
+	}
+}
+
+// Add a line to the prelude (top level data and radix definitions)
+func (status *CompilerStatus) addPrelude(format string, a ...any) {
+	status.prelude.WriteString(fmt.Sprintf(format, a...) + "\n")
+}
+
+// Add a word to the code to be executed at compile time
+func (status *CompilerStatus) interpretToken(tokens *Tokenizer, token string) error {
+	switch strings.ToUpper(token) {
+	case "(":
+		tokens.Parse(')')
+		return nil
+	case "\\":
+		tokens.SkipLine()
+		return nil
+	case `S"`, `."`, `C"`, `ABORT"`:
+		text, err := parseText(tokens, token, '"')
+		if err != nil {
+			return err
+		}
+		token = fmt.Sprintf(`%s %s"`, token, text)
+	}
+	status.interpretation.WriteString(token + " ")
+	return nil
+}
+
+// Return the machine executing the code at compile time, after loading the prelude
+func (status *CompilerStatus) evaluatorMachine() (*Repl, error) {
+	if status.machine == nil {
+		output := status.console
+		if status.pass != Second {
+			output = io.Discard // display the output once
+		}
+		status.machine = NewRepl(os.Stdin, output)
+		status.machine.session = newSession(status.standard)
+		status.machine.session.status.evaluator = true
+		status.machine.session.SetConsole(output)
+	}
+	machine := status.machine
+	machine.session.status.immediate = status.immediate
+	if prelude := status.prelude.String(); status.loaded < len(prelude) {
+		machine.cpu.Limit = machine.cpu.Time + evaluationLimit
+		if err := machine.Execute(prelude[status.loaded:]); err != nil {
+			return nil, err
+		}
+		status.loaded = len(prelude)
+	}
+	return machine, nil
+}
+
+// Release the machine executing the code at compile time
+func (status *CompilerStatus) closeEvaluator() {
+	if status.machine != nil {
+		status.machine.Close()
+		status.machine = nil
+	}
+}
+
+// Execute Forth code at compile time, return the text to be compiled
+func (status *CompilerStatus) evaluate(code string) (string, error) {
+	machine, err := status.evaluatorMachine()
+	if err != nil {
+		return "", err
+	}
+	var source strings.Builder
+	for _, value := range status.literals {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", NewCompilerError(fmt.Sprintf("%s: value not available at compile time", strings.ToLower(value)))
+		}
+		source.WriteString(strconv.FormatInt(int64(n), status.base) + " ")
+	}
+	status.literals = status.literals[:0]
+	for _, r := range status.floats {
+		source.WriteString(formatFloat(r) + " ")
+	}
+	status.floats = status.floats[:0]
+	source.WriteString("\n" + code + "\n")
+	// Leave the address of the compile buffer and the compile pointer on the stack
+	source.WriteString(fmt.Sprintf(";code push %s push %s fetch ;\n", compileBuffer, compilePointer))
+
+	// Each execution starts with empty stacks and an empty compile buffer
+	cpu := machine.cpu
+	cpu.Ds.Reset()
+	cpu.Fs.Reset()
+	if pointer, exists := machine.symbols[strings.ToUpper(compilePointer)]; exists {
+		cpu.WriteWord(fcpu.Addr(pointer), machine.symbols[strings.ToUpper(compileBuffer)])
+	}
+	cpu.Limit = cpu.Time + evaluationLimit
+	if err = machine.Execute(source.String()); err != nil {
+		return "", err
+	}
+	if cpu.Time >= cpu.Limit {
+		return "", NewCompilerError("compile-time execution: instructions limit exceeded")
+	}
+
+	stack := cpu.Ds.Array()
+	if len(stack) < 2 {
+		return "", NewCompilerError("compile-time execution: stack underflow")
+	}
+	start, end := fcpu.Addr(stack[len(stack)-2]), fcpu.Addr(stack[len(stack)-1])
+	if end < start || end-start > compileBufferSize {
+		return "", NewCompilerError("compile-time execution: compile buffer overflow")
+	}
+	for _, value := range stack[:len(stack)-2] {
+		if status.machineAddress(fcpu.Addr(value)) {
+			return "", NewCompilerError("compile-time execution: address not available at run time")
+		}
+		status.pushNumber(int(value))
+	}
+	for _, value := range cpu.Fs.Array() {
+		status.floats = append(status.floats, math.Float32frombits(uint32(value)))
+	}
+	return string(cpu.ReadBytes(start, end-start)), nil
+}
+
+// Return true if addr is in the code, in the data space or in the heap of the machine executing the code at compile time
+func (status *CompilerStatus) machineAddress(addr fcpu.Addr) bool {
+	machine := status.machine
+	// The data space reserved at top level by the program is not reserved in the machine
+	end := machine.here() + fcpu.Addr(status.dataSize)
+	return (addr >= asm.TextSegment && addr < machine.text) ||
+		(addr >= asm.DataSegment && addr <= end) ||
+		(addr >= fcpu.HeapStart && addr < fcpu.HeapEnd)
+}
+
+// Compile the text generated by the code executed at compile time
+func (status *CompilerStatus) compileGenerated(text string) error {
+	if err := CompileLine(status, text); err != nil {
+		return err
+	}
+	status.replace(text)
+	return nil
+}
+
+// Write the compile buffer, used by the code executed at compile time
+func (status *CompilerStatus) writeCompileBuffer() {
+	if status.evaluator || status.session || status.library["(COMPILE-CHAR)"] {
+		status.output.WriteString(fmt.Sprintf("%s: .word %s\n", compilePointer, compileBuffer))
+		status.output.WriteString(fmt.Sprintf("%s: .space %d\n", compileBuffer, compileBufferSize))
+	}
+}
@@ -0,0 +1,155 @@
+package forth
+
+import (
+	"fmt"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"strconv"
+	"strings"
+)
+
+// Address of a register of the heap device
+func heapRegister(offset fcpu.Addr) fcpu.Addr {
+	return fcpu.HeapRegisters + offset
+}
+
+// Library words
+//
+// The library words are compiled as subroutines, only if used by the program.
+// They are compiled after the program with the standard definitions, so they
+// are not affected by the redefinitions in the program.
+var Library = map[string]string{
+	/* Arithmetic */
+	"FM/MOD": "dup >r sm/rem over dup 0 <> swap 0< r@ 0< xor and if 1- swap r> + swap else r> drop then", // ( d n1 -- n2 n3 ) Divide d by n1 (floored division), giving the remainder n2 and the quotient n3.
+
+	/* Strings */
+	"COMPARE": "rot 2dup 2>r min 0 ?do over i + c@ over i + c@ - ?dup if nip nip 0< 2* 1+ unloop 2r> 2drop exit then loop 2drop 2r> swap - dup if 0< 2* 1+ then", // ( c-addr1 u1 c-addr2 u2 -- n ) Compare the two strings, n is -1, 0 or 1.
+	"SEARCH":  "2>r 2dup begin dup r@ < 0= while over r@ 2r@ compare 0= if 2swap 2drop 2r> 2drop true exit then 1- swap 1+ swap repeat 2drop 2r> 2drop false",    // ( c-addr1 u1 c-addr2 u2 -- c-addr3 u3 flag ) Search the first string for the second string, c-addr3 u3 is the rest of the first string from the match.
+
+	/* Dynamic memory (the heap device allocates the blocks, see fcpu.Heap) */
+	"ALLOCATE": fmt.Sprintf(";code push %d store push %d push %d store push %d fetch push %d fetch ;", heapRegister(fcpu.HeapSize), fcpu.HeapAllocate, heapRegister(fcpu.HeapCommand), heapRegister(fcpu.HeapAddress), heapRegister(fcpu.HeapIor)),                                             // ( u -- a-addr ior ) Allocate u bytes of data space, ior is 0 on success.
+	"FREE":     fmt.Sprintf(";code push %d store push %d push %d store push %d fetch ;", heapRegister(fcpu.HeapAddress), fcpu.HeapFree, heapRegister(fcpu.HeapCommand), heapRegister(fcpu.HeapIor)),                                                                                            // ( a-addr -- ior ) Return the space allocated at a-addr, ior is 0 on success.
+	"RESIZE":   fmt.Sprintf(";code push %d store push %d store push %d push %d store push %d fetch push %d fetch ;", heapRegister(fcpu.HeapSize), heapRegister(fcpu.HeapAddress), fcpu.HeapResize, heapRegister(fcpu.HeapCommand), heapRegister(fcpu.HeapAddress), heapRegister(fcpu.HeapIor)), // ( a-addr1 u -- a-addr2 ior ) Change the size of the space allocated at a-addr1 to u bytes, ior is 0 on success.
+
+	/* Cooperative multitasking */
+	"PAUSE":        fmt.Sprintf(";code pushsp push %[1]s fetch push %[2]d add store pushsb push %[1]s fetch push %[3]d add store pushrsp push %[1]s fetch push %[4]d add store pushrbp push %[1]s fetch push %[5]d add store push %[6]s fetch push %[1]s fetch push %[7]d add store push %[1]s fetch fetch push %[1]s store push %[1]s fetch push %[7]d add fetch push %[6]s store push %[1]s fetch push %[5]d add fetch poprbp push %[1]s fetch push %[4]d add fetch poprsp push %[1]s fetch push %[3]d add fetch popsb push %[1]s fetch push %[2]d add fetch popsp ;", currentTask, taskSp, taskSb, taskRsp, taskRbp, catchHandler, taskHandler), // ( -- ) Switch to the next task.
+	"ACTIVATE":     fmt.Sprintf(">r r@ (unlink) 0 r@ %[9]d + ! r@ %[1]d + dup r@ %[2]d + ! %[3]d - swap over ! r@ %[4]d + ! ['] (task-entry) r@ %[5]d + dup r@ %[6]d + ! %[3]d - swap over ! r@ %[7]d + ! ;code push %[8]s fetch ; dup @ r@ ! r> swap !", taskDataBase, taskSb, fcpu.WordSize, taskSp, taskReturnBase, taskRbp, taskRsp, currentTask, taskHandler),                                                                                                                                                                                                                                                                                 // ( xt task -- ) Start the task executing xt, a running task is restarted.
+	"(TASK-ENTRY)": "execute stop",                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                 // ( xt -- ) Execute xt in a new task, then stop the task.
+	"STOP":         fmt.Sprintf(";code push %s fetch ; (unlink) pause", currentTask),                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                               // ( -- ) Stop the current task.
+	"(UNLINK)":     fmt.Sprintf("dup @ over = if ;code hlt ; then ;code push %[1]s fetch ; begin 2dup @ <> over @ ;code push %[1]s fetch ; <> and while @ repeat 2dup @ = if swap @ swap ! else 2drop then", currentTask),                                                                                                                                                                                                                                                                                                                                                                                                                          // ( task -- ) Remove the task from the list (if running), terminate the program if it is the last task.
+	"SEND":         "begin dup cell+ @ while pause repeat swap over ! true swap cell+ !",                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                           // ( x mailbox -- ) Wait until the mailbox is empty, then store the message x.
+	"RECEIVE":      "begin dup cell+ @ 0= while pause repeat dup @ swap cell+ 0 swap !",                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                            // ( mailbox -- x ) Wait until the mailbox contains a message, then remove the message x.
+	"ACQUIRE":      "begin dup @ 0= while pause repeat -1 swap +!",                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                 // ( semaphore -- ) Wait until the semaphore is greater than zero, then decrement it.
+	"RELEASE":      "1 swap +!",                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                    // ( semaphore -- ) Increment the semaphore.
+
+	/* Pictured numeric output */
+	"#":  "0 base @ um/mod >r base @ um/mod r> rot dup 9 > if 7 + then 48 + hold", // ( ud1 -- ud2 ) Divide ud1 by the number in BASE, add the remainder digit to the pictured numeric output.
+	"#S": "begin # 2dup or 0= until",                                              // ( ud1 -- ud2 ) Convert one digit of ud1 at a time, until the quotient is zero.
+
+	/* Compile buffer, used by the words executed at compile time */
+	"(COMPILE-CHAR)":   fmt.Sprintf(";code push %s fetch store_b push %s fetch push 1 add push %s store ;", compilePointer, compilePointer, compilePointer), // ( char -- ) Append char to the compile buffer.
+	"(COMPILE-TEXT)":   "begin dup while over c@ (compile-char) 1- swap 1+ swap repeat 2drop bl (compile-char)",                                             // ( c-addr u -- ) Append the string and a space to the compile buffer.
+	"(COMPILE-NUMBER)": "35 (compile-char) base @ swap decimal dup abs 0 <# #s rot sign #> (compile-text) base !",                                           // ( n -- ) Append the decimal number n to the compile buffer.
+
+	/* Exception handling
+	 * CATCH pushes an exception frame on the return stack (data stack depth, RBP and previous frame)
+	 * and THROW restores the stack pointers saved in the frame. The CPU traps are
+	 * converted to the standard THROW codes by (TRAP), installed by CATCH and removed
+	 * when the outermost frame is popped. */
+	"CATCH":       fmt.Sprintf("['] (trap) %d ! depth 1- >r ;code pushrbp to_r push %s fetch to_r pushrsp push %s store ; execute r> ;code push %s store ; (end-catch) r> drop r> drop 0", fcpu.TrapVector, catchHandler, catchHandler, catchHandler), // ( i*x xt -- j*x 0 | i*x n ) Execute xt, return the THROW code n (0 if no THROW occurred).
+	"THROW":       fmt.Sprintf("?dup if ;code push %s fetch ; if ;code push %s fetch poprsp ; r> ;code push %s store r_from poprbp ; (end-catch) r> swap >r (set-depth) r> else (uncaught) then then", catchHandler, catchHandler, catchHandler),      // ( k*x n -- k*x | i*x n ) If n is not zero, return to the innermost CATCH, restoring the data stack depth.
+	"(END-CATCH)": fmt.Sprintf(";code push %s fetch ; 0= if 0 %d ! then", catchHandler, fcpu.TrapVector),                                                                                                                                              // ( -- ) Remove the trap handler if there are no exception frames.
+	"(SET-DEPTH)": "begin depth 1- over > while nip repeat begin depth 1- over < while 0 swap repeat drop",                                                                                                                                            // ( i*x n -- j*x ) Remove or add items, leaving n items on the stack.
+	"(UNCAUGHT)":  fmt.Sprintf(`dup -2 = if ;code push %s ; 2@ type else dup -1 <> if ." Error " dup . then then 0 (set-depth) ;code push %d poprbp hlt ;`, abortMessage, fcpu.ReturnStackTop),                                                        // ( i*x n -- ) Display the error, empty the data stack and terminate the program.
+	"(ABORT\")":   fmt.Sprintf(";code push %s ; 2! -2 throw", abortMessage),                                                                                                                                                                           // ( c-addr u -- ) Save the message and perform -2 THROW.
+	"(TRAP)":      fmt.Sprintf("dup %d = if drop -10 else dup %d = if drop -9 else -256 swap - then then throw", fcpu.DivisionByZero, fcpu.InvalidAddress),                                                                                            // ( code -- ) Perform the THROW of a CPU trap (-10 division by zero, -9 invalid address, -256-code for the other traps).
+
+	/* Deferred words */
+	"(DEFER)": `@ dup 0= abort" deferred word not set" execute`, // ( a-addr -- ) Execute the execution token stored at a-addr.
+
+	/* Numeric output */
+	".":   "dup abs 0 <# #s rot sign #> type space",                                                    // ( n -- ) Display n followed by a space.
+	"D.":  "swap over dabs <# #s rot sign #> type space",                                               // ( d -- ) Display d followed by a space.
+	"U.":  "0 <# #s #> type space",                                                                     // ( u -- ) Display u followed by a space.
+	".R":  "swap dup abs 0 <# #s rot sign #> rot over - spaces type",                                   // ( n1 n2 -- ) Display n1 right aligned in a field n2 characters wide.
+	"U.R": "swap 0 <# #s #> rot over - spaces type",                                                    // ( u n -- ) Display u right aligned in a field n characters wide.
+	".S":  "depth 60 emit dup 0 <# #s #> type 62 emit space begin dup while dup pick . 1- repeat drop", // ( -- ) Display the stack content, without altering the stack.
+}
+
+// Subroutine compiled after the program
+type subroutine struct {
+	label      string
+	definition string
+}
+
+// Add a subroutine to be compiled after the program
+func (status *CompilerStatus) addSubroutine(label string, definition string) {
+	status.pending = append(status.pending, subroutine{label: label, definition: definition})
+	status.labels[strings.ToUpper(label)] = true
+}
+
+// Return the definition calling a library word, the word is added to the subroutines to be compiled
+func (status *CompilerStatus) libraryWord(name string) (string, bool) {
+	definition, exists := status.standard.library[name]
+	if !exists {
+		return "", false
+	}
+	label := wordLabel(name, "lib")
+	if !status.library[name] {
+		status.library[name] = true
+		status.addSubroutine(label, definition)
+	}
+	return fmt.Sprintf("%s call", label), true
+}
+
+// Return the execution token (the label of a subroutine) of a word
+func (status *CompilerStatus) executionToken(name string) (string, error) {
+	definition, exists := status.dictionary[name]
+	if !exists {
+		definition, exists = status.libraryWord(name)
+	}
+	if value, isConstant := status.constants[name]; isConstant && !exists {
+		definition, exists = strconv.Itoa(value), true
+	}
+	if !exists {
+		return "", NewCompilerError(fmt.Sprintf("%s ?", strings.ToLower(name)))
+	}
+	if fields := strings.Fields(definition); len(fields) == 2 && fields[1] == "call" {
+		return fields[0], nil // colon definition or library word
+	}
+	// The word is compiled inline, add a subroutine executing the word
+	label, exists := status.xts[name]
+	if !exists {
+		label = status.newLabel(name, "xt")
+		status.xts[name] = label
+		status.addSubroutine(label, definition)
+	}
+	return label, nil
+}
+
+// Compile the subroutines used by the program (and by the subroutines)
+func (status *CompilerStatus) compileLibrary() error {
+	// The library words are compiled with the standard words, not with the words of the program
+	dictionary, constants, immediate, defining, base := status.dictionary, status.constants, status.immediate, status.defining, status.base
+	defer func() {
+		status.dictionary, status.constants, status.immediate, status.defining, status.base = dictionary, constants, immediate, defining, base
+	}()
+	status.dictionary = cloneMap(status.standard.definitions)
+	status.constants = cloneMap(status.standard.constants)
+	status.immediate = map[string]bool{}
+	status.defining = map[string]string{}
+	status.base = 10
+	for len(status.pending) > 0 {
+		s := status.pending[0]
+		status.pending = status.pending[1:]
+		status.context.Enter(Colon)
+		if status.pass == Second {
+			status.Add(fmt.Sprintf("%s:", s.label))
+		}
+		if err := CompileLine(status, s.definition); err != nil {
+			return err
+		}
+		status.Add("  ret")
+		status.context.Exit()
+	}
+	return nil
+}
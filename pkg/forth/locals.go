@@ -0,0 +1,103 @@
+package forth
+
+import (
+	"fmt"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"strings"
+)
+
+// Locals
+//
+// {: a b | c -- d :} declares the locals of a colon definition: a and b are
+// initialized with the items on the stack (b with the top item), c is
+// initialized to zero, the names after -- are a comment.
+//
+// The locals are allocated in a frame on the return stack, addressed by RBP.
+// The frame contains the RBP of the caller, followed by the locals:
+//
+//	rbp + 0    RBP of the caller
+//	rbp - 4    last argument
+//	...        other arguments and uninitialized locals
+//
+// The frame is removed by ; and EXIT. The name of a local returns its value,
+// TO name stores a value into the local.
+
+// Parse the locals declaration and compile the creation of the frame
+func (status *CompilerStatus) compileLocals(tokens *Tokenizer) error {
+	if !status.context.Is(Colon) || status.interpreting {
+		return NewCompilerError("{:: not allowed outside a definition")
+	}
+	if status.locals != nil {
+		return NewCompilerError("{:: locals already declared")
+	}
+	var args, values []string
+	names := &args
+	comment := false
+	for {
+		name, ok := tokens.Word()
+		if !ok {
+			return NewCompilerError("{:: missing :}")
+		}
+		name = strings.ToUpper(name)
+		if name == ":}" {
+			break
+		}
+		switch {
+		case comment:
+		case name == "--":
+			comment = true
+		case name == "|" && names == &args:
+			names = &values
+		default:
+			*names = append(*names, name)
+		}
+	}
+	status.locals = map[string]int{}
+	code := []string{"  pushrbp to_r pushrsp poprbp"}
+	offset := 0
+	for i := len(args) - 1; i >= 0; i-- {
+		offset += int(fcpu.WordSize)
+		status.locals[args[i]] = offset
+		code = append(code, "  to_r")
+	}
+	for _, name := range values {
+		offset += int(fcpu.WordSize)
+		status.locals[name] = offset
+		code = append(code, "  push 0 to_r")
+	}
+	if status.pass == Second {
+		status.Add(strings.Join(code, "\n"))
+	}
+	return nil
+}
+
+// Compile the code removing the frame of the locals, before returning from the definition
+func (status *CompilerStatus) compileFrameExit() {
+	if status.locals != nil && status.pass == Second {
+		status.Add("  pushrbp poprsp r_from poprbp")
+	}
+}
+
+// Compile the value of a local, return false if name is not a local
+func (status *CompilerStatus) compileLocal(name string) bool {
+	offset, isLocal := status.locals[name]
+	if !isLocal || status.nesting != 1 {
+		return false
+	}
+	if status.pass == Second {
+		status.Add(fmt.Sprintf("  pushrbp push %d sub fetch", offset))
+	}
+	return true
+}
+
+// Compile TO name for a local, return false if name is not a local
+func (status *CompilerStatus) compileLocalStore(name string) bool {
+	offset, isLocal := status.locals[name]
+	if !isLocal {
+		return false
+	}
+	if status.pass == Second {
+		status.Add(fmt.Sprintf("  pushrbp push %d sub store", offset))
+	}
+	return true
+}
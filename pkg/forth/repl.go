@@ -0,0 +1,369 @@
+package forth
+
+import (
+	"bufio"
+	"fmt"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Interactive Forth
+//
+// The REPL reads the input line by line. When a unit is complete (see
+// Session), it is compiled, assembled at the end of the code of the previous
+// units and executed by the same machine: the data space and the data stack
+// are kept between the units. The data stack is displayed after every unit.
+//
+// The lines starting with a colon followed by a name are commands:
+//
+//	:help            display the commands
+//	:words           display the words defined in the session
+//	:mem addr [n]    display n bytes of memory, starting at addr (a number or a word)
+//	:history         display the history, !! repeats the last unit, !n repeats the n-th unit
+//	:reset           reset the machine and the dictionary
+//	:quit            exit
+
+const replHelp = `:help            display the commands
+:words           display the words defined in the session
+:mem addr [n]    display n bytes of memory, starting at addr (a number or a word)
+:history         display the history, !! repeats the last unit, !n repeats the n-th unit
+:reset           reset the machine and the dictionary
+:quit            exit
+`
+
+// Default number of bytes displayed by :mem
+const memDumpSize = 64
+
+type Repl struct {
+	input       *bufio.Reader // input of the REPL and of KEY
+	output      *trackingWriter
+	cpu         *fcpu.CPU
+	session     *Session
+	symbols     map[string]fcpu.Word // addresses of the labels defined by the units
+	text        fcpu.Addr            // address of the code of the next unit
+	history     []string
+	HistoryFile string // file where the history is loaded from and saved to (optional)
+}
+
+// Writer remembering if the last char written is a newline
+type trackingWriter struct {
+	w       io.Writer
+	newline bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		t.newline = p[len(p)-1] == '\n'
+	}
+	return t.w.Write(p)
+}
+
+func NewRepl(input io.Reader, output io.Writer) *Repl {
+	repl := new(Repl)
+	repl.input = bufio.NewReader(input)
+	repl.output = &trackingWriter{w: output, newline: true}
+	repl.Reset()
+	return repl
+}
+
+// Reset the machine and the dictionary
+func (repl *Repl) Reset() {
+	if repl.cpu != nil {
+		repl.cpu.Close()
+	}
+	repl.cpu = fcpu.New()
+	repl.cpu.Output = repl.output
+	repl.cpu.Input = repl.input
+	repl.session = NewSession()
+	repl.session.SetConsole(repl.output)
+	repl.symbols = map[string]fcpu.Word{}
+	repl.text = asm.TextSegment
+}
+
+// Return the CPU executing the units
+func (repl *Repl) CPU() *fcpu.CPU {
+	return repl.cpu
+}
+
+// Release the machine, the REPL can not be used after Close
+func (repl *Repl) Close() error {
+	return repl.cpu.Close()
+}
+
+// Read and execute the input, until the end of the input or :quit
+func (repl *Repl) Run() error {
+	repl.loadHistory()
+	unit := ""
+	repl.prompt(unit)
+	for {
+		line, err := repl.input.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		repl.output.newline = true // the input line is terminated by a newline
+		if unit == "" {
+			command := strings.TrimSpace(line)
+			if command == "" {
+				repl.prompt(unit)
+				continue
+			}
+			if len(command) > 1 && command[0] == ':' && !isSpace(command[1]) {
+				if quit := repl.command(command); quit {
+					return nil
+				}
+				repl.prompt(unit)
+				continue
+			}
+			if strings.HasPrefix(command, "!") {
+				var err error
+				if line, err = repl.recall(command); err != nil {
+					fmt.Fprintln(repl.output, err)
+					repl.prompt(unit)
+					continue
+				}
+				fmt.Fprintln(repl.output, line)
+			}
+		}
+		if unit == "" {
+			unit = line
+		} else {
+			unit += "\n" + line
+		}
+		if !repl.session.Complete(unit) {
+			repl.prompt(unit)
+			continue
+		}
+		repl.addHistory(unit)
+		if err := repl.Execute(unit); err != nil {
+			if !repl.output.newline {
+				fmt.Fprintln(repl.output)
+			}
+			fmt.Fprintln(repl.output, err)
+		}
+		repl.printStack()
+		unit = ""
+		repl.prompt(unit)
+	}
+}
+
+// Display the prompt, a different prompt is displayed inside a multi-line unit
+func (repl *Repl) prompt(unit string) {
+	if unit == "" {
+		fmt.Fprint(repl.output.w, "> ")
+	} else {
+		fmt.Fprint(repl.output.w, "... ")
+	}
+}
+
+// Compile a unit, load it into the memory and execute it
+func (repl *Repl) Execute(unit string) error {
+	source, err := repl.session.Compile(unit)
+	if err != nil {
+		return err
+	}
+	// The labels of the previous units are defined as symbols
+	options := asm.Options{Object: true, TextBase: repl.text, DataBase: repl.here(), Defines: map[string]fcpu.Word{}}
+	for _, label := range repl.session.Labels() {
+		if value, exists := repl.symbols[label]; exists {
+			options.Defines[label] = value
+		}
+	}
+	obj, err := asm.AssembleObject("unit.pal", source, options)
+	if err != nil {
+		return err
+	}
+	// Load the unit
+	repl.cpu.WriteBytes(options.TextBase, obj.Text)
+	repl.cpu.WriteBytes(options.DataBase, obj.Data)
+	for _, symbol := range obj.Symbols {
+		if !symbol.IsExtern() {
+			repl.symbols[symbol.Name] = fcpu.Word(symbol.Value)
+		}
+	}
+	repl.session.Commit()
+	repl.text = alignAddr(options.TextBase + fcpu.Addr(len(obj.Text)))
+	repl.cpu.WriteWord(fcpu.Addr(repl.symbols[strings.ToUpper(herePointer)]), fcpu.Word(options.DataBase)+fcpu.Word(len(obj.Data)))
+	// Execute the unit
+	repl.cpu.Jump(options.TextBase)
+	err = repl.run()
+	// The return stack, the locals frames and the exception frames are not kept between the units
+	repl.cpu.Rs.ResetOrigin()
+	repl.cpu.WriteWord(fcpu.Addr(repl.symbols[strings.ToUpper(catchHandler)]), 0)
+	repl.cpu.WriteWord(fcpu.TrapVector, 0)
+	if err != nil {
+		return err
+	}
+	if repl.cpu.Ds.Underflow() {
+		repl.cpu.Ds.Reset()
+		return NewCompilerError("stack underflow")
+	}
+	return nil
+}
+
+// Run the machine until HLT
+func (repl *Repl) run() error {
+	for {
+		err := repl.cpu.Eval()
+		if _, halt := err.(*fcpu.Halt); halt {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Return the address of the data of the next unit (the aligned data space pointer)
+func (repl *Repl) here() fcpu.Addr {
+	pointer, exists := repl.symbols[strings.ToUpper(herePointer)]
+	if !exists {
+		return asm.DataSegment
+	}
+	return alignAddr(fcpu.Addr(repl.cpu.ReadWord(fcpu.Addr(pointer))))
+}
+
+// Display the data stack
+func (repl *Repl) printStack() {
+	if !repl.output.newline {
+		fmt.Fprintln(repl.output)
+	}
+	stack := repl.cpu.Ds.Array()
+	fmt.Fprintf(repl.output, "<%d>", len(stack))
+	for _, value := range stack {
+		fmt.Fprintf(repl.output, " %d", value)
+	}
+	fmt.Fprintln(repl.output)
+}
+
+// Execute a command, return true to exit
+func (repl *Repl) command(line string) bool {
+	fields := strings.Fields(line)
+	switch strings.ToLower(fields[0]) {
+	case ":help":
+		fmt.Fprint(repl.output, replHelp)
+	case ":quit", ":q":
+		return true
+	case ":reset":
+		repl.Reset()
+	case ":words":
+		words := repl.session.Words()
+		sort.Strings(words)
+		fmt.Fprintln(repl.output, strings.Join(words, " "))
+	case ":history":
+		for i, unit := range repl.history {
+			fmt.Fprintf(repl.output, "%4d  %s\n", i+1, strings.ReplaceAll(unit, "\n", "\n      "))
+		}
+	case ":mem":
+		if err := repl.dumpMemory(fields[1:]); err != nil {
+			fmt.Fprintln(repl.output, err)
+		}
+	default:
+		fmt.Fprintf(repl.output, "%s: unknown command\n", fields[0])
+	}
+	return false
+}
+
+// Display the memory, the arguments are the address and the number of bytes
+func (repl *Repl) dumpMemory(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return NewCompilerError("usage: :mem addr [n]")
+	}
+	var address fcpu.Addr
+	if label, exists := repl.session.Label(args[0]); exists {
+		address = fcpu.Addr(repl.symbols[label])
+	} else if n, err := strconv.ParseUint(args[0], 0, 32); err == nil {
+		address = fcpu.Addr(n)
+	} else {
+		return NewCompilerError(fmt.Sprintf("%s ?", args[0]))
+	}
+	size := fcpu.Addr(memDumpSize)
+	if len(args) == 2 {
+		n, err := strconv.ParseUint(args[1], 0, 32)
+		if err != nil {
+			return NewCompilerError(fmt.Sprintf("%s: invalid size", args[1]))
+		}
+		size = fcpu.Addr(n)
+	}
+	data := repl.cpu.ReadBytes(address, size)
+	for offset := 0; offset < len(data); offset += 16 {
+		line := data[offset:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		fmt.Fprintf(repl.output, "%08x ", address+fcpu.Addr(offset))
+		for i := 0; i < 16; i++ {
+			if i < len(line) {
+				fmt.Fprintf(repl.output, " %02x", line[i])
+			} else {
+				fmt.Fprint(repl.output, "   ")
+			}
+		}
+		fmt.Fprint(repl.output, "  |")
+		for _, ch := range line {
+			if ch < ' ' || ch > '~' {
+				ch = '.'
+			}
+			fmt.Fprintf(repl.output, "%c", ch)
+		}
+		fmt.Fprintln(repl.output, "|")
+	}
+	return nil
+}
+
+// Return the unit referenced by !! (the last unit) or by !n (the n-th unit)
+func (repl *Repl) recall(command string) (string, error) {
+	if command == "!!" {
+		if len(repl.history) == 0 {
+			return "", NewCompilerError("!!: history is empty")
+		}
+		return repl.history[len(repl.history)-1], nil
+	}
+	n, err := strconv.Atoi(command[1:])
+	if err != nil || n < 1 || n > len(repl.history) {
+		return "", NewCompilerError(fmt.Sprintf("%s: event not found", command))
+	}
+	return repl.history[n-1], nil
+}
+
+// Add a unit to the history, the unit is appended to the history file
+func (repl *Repl) addHistory(unit string) {
+	repl.history = append(repl.history, unit)
+	if repl.HistoryFile == "" {
+		return
+	}
+	file, err := os.OpenFile(repl.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.WriteString(strings.ReplaceAll(unit, "\n", "\\n") + "\n")
+}
+
+// Load the history file
+func (repl *Repl) loadHistory() {
+	if repl.HistoryFile == "" {
+		return
+	}
+	data, err := os.ReadFile(repl.HistoryFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line != "" {
+			repl.history = append(repl.history, strings.ReplaceAll(line, "\\n", "\n"))
+		}
+	}
+}
+
+// Round up an address to the word size
+func alignAddr(addr fcpu.Addr) fcpu.Addr {
+	return (addr + fcpu.WordSize - 1) &^ (fcpu.WordSize - 1)
+}
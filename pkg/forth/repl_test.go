@@ -0,0 +1,154 @@
+package forth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Run the REPL, return the output without the prompts
+func runRepl(t *testing.T, input string) (*Repl, string) {
+	var output bytes.Buffer
+	repl := NewRepl(strings.NewReader(input), &output)
+	if err := repl.Run(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(output.String(), "\n")
+	for i, line := range lines {
+		for strings.HasPrefix(line, "> ") || strings.HasPrefix(line, "... ") {
+			line = line[strings.Index(line, " ")+1:]
+		}
+		lines[i] = line
+	}
+	return repl, strings.Join(lines, "\n")
+}
+
+func testRepl(t *testing.T, input string, expected string) {
+	if _, result := runRepl(t, input); result != expected {
+		t.Errorf("%q: expected %q, got %q", input, expected, result)
+	}
+}
+
+func TestRepl(t *testing.T) {
+	testRepl(t, "1 2\n+\n", "<2> 1 2\n<1> 3\n")
+	testRepl(t, ": sq dup * ;\n5 sq\n", "<0>\n<1> 25\n")
+	testRepl(t, "variable x\n42 x !\nx @ .\n", "<0>\n<0>\n42 \n<0>\n")
+	testRepl(t, "3 constant three\nthree three *\n", "<0>\n<1> 9\n")
+	testRepl(t, "create a 1 , 2 ,\na cell+ @\n", "<0>\n<1> 2\n")
+	testRepl(t, "1 2 .s\n", "<2> 1 2 \n<2> 1 2\n")
+	testRepl(t, ": hi .\" hi\" ;\nhi hi\n", "<0>\nhihi\n<0>\n")
+	testRepl(t, "\n\n1\n", "<1> 1\n")
+	testRepl(t, ": t {: a b :} a b - ;\n5 3 t\n", "<0>\n<1> 2\n")
+	testRepl(t, "5 value v\nv\n9 to v v\ndefer d\n' dup is d\n1 d\n", "<0>\n<1> 5\n<2> 5 9\n<2> 5 9\n<2> 5 9\n<4> 5 9 1 1\n")
+	testRepl(t, "key\nA\n", "<1> 65\n")
+	testRepl(t, "2.5e0\nfdup f* f.\n", "<0>\n6.25 \n<0>\n")
+	testRepl(t, "task t\n: w 2 0 do i . pause loop ;\n' w t activate 7\npause\npause\n", "<0>\n<0>\n<1> 7\n0 \n<1> 7\n1 \n<1> 7\n")
+	// Library words and subroutines used by different units
+	testRepl(t, "1 .\n2 .\n: t ['] dup ;\nt t =\n", "1 \n<0>\n2 \n<0>\n<0>\n<1> -1\n")
+	// The numbers are converted with the current radix
+	testRepl(t, "hex\nff\ndecimal 10\n", "<0>\n<1> 255\n<2> 255 10\n")
+}
+
+func TestReplMultiLine(t *testing.T) {
+	testRepl(t, ": sq\n  dup *\n;\n4 sq\n", "<0>\n<1> 16\n")
+	testRepl(t, ": t 3 0 do\n  i\nloop ;\nt\n", "<0>\n<3> 0 1 2\n")
+	testRepl(t, "( a\ncomment ) 7\n", "<1> 7\n")
+	testRepl(t, "1 if\n2\nthen\n", "<1> 2\n")
+}
+
+func TestReplErrors(t *testing.T) {
+	testRepl(t, "1 nosuch\n2\n", "Forth compiler error: nosuch ?\n<0>\n<1> 2\n")
+	testRepl(t, "1\ndrop drop\n3\n", "<1> 1\nForth compiler error: stack underflow\n<0>\n<1> 3\n")
+	// The definitions of a unit with errors are discarded
+	testRepl(t, ": t 1 nosuch ;\nt\n", "Forth compiler error: nosuch ?\n<0>\nForth compiler error: t ?\n<0>\n")
+	testRepl(t, "then\n", "Forth compiler error: Unbalanced control structure 'then'\n<0>\n")
+	testRepl(t, ": t 1 if 2 ;\n", "Forth compiler error: Unterminated control structure 'if', 'then' expected\n<0>\n")
+}
+
+func TestReplCommands(t *testing.T) {
+	testRepl(t, "1\n:reset\n2\n", "<1> 1\n<1> 2\n")
+	testRepl(t, ": w1 ;\nvariable w2\n:words\n", "<0>\n<0>\nw1 w2\n")
+	testRepl(t, "1\n2\n!!\n!1\n!9\n", "<1> 1\n<2> 1 2\n2\n<3> 1 2 2\n1\n<4> 1 2 2 1\nForth compiler error: !9: event not found\n")
+	testRepl(t, "1\n: t\n;\n:history\n", "<1> 1\n<1> 1\n   1  1\n   2  : t\n      ;\n")
+	testRepl(t, ":mem\n:mem y\n", "Forth compiler error: usage: :mem addr [n]\nForth compiler error: y ?\n")
+	testRepl(t, ":foo\n:quit\n1\n", ":foo: unknown command\n")
+}
+
+func TestReplTrap(t *testing.T) {
+	repl := NewRepl(strings.NewReader(""), io.Discard)
+	if err := repl.Execute(": t {: a :} a 0 / ;"); err != nil {
+		t.Fatal(err)
+	}
+	var trap *fcpu.Trap
+	if err := repl.Execute("1 t"); !errors.As(err, &trap) {
+		t.Fatalf("Trap expected, got %v", err)
+	}
+	// The return stack origin, moved by the locals frame, is restored
+	repl.cpu.Ds.Reset()
+	if err := repl.Execute(";code pushrbp pushrsp ;"); err != nil {
+		t.Fatal(err)
+	}
+	if stack := repl.cpu.Ds.Array(); !reflect.DeepEqual(stack, []fcpu.Word{fcpu.ReturnStackTop, fcpu.ReturnStackTop}) {
+		t.Errorf("Return stack origin and pointer %x expected, got %x", fcpu.ReturnStackTop, stack)
+	}
+}
+
+func TestReplMemory(t *testing.T) {
+	repl, result := runRepl(t, "variable x 1145258561 x !\n:mem x 4\n")
+	label, _ := repl.session.Label("x")
+	expected := fmt.Sprintf("<0>\n%08x  41 42 43 44                                      |ABCD|\n", repl.symbols[label])
+	if result != expected {
+		t.Errorf("mem: expected %q, got %q", expected, result)
+	}
+}
+
+func TestReplHistoryFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	historyFile := filepath.Join(tmpDir, "history")
+
+	var output bytes.Buffer
+	repl := NewRepl(strings.NewReader("1\n: t\n;\n"), &output)
+	repl.HistoryFile = historyFile
+	if err = repl.Run(); err != nil {
+		t.Fatal(err)
+	}
+	repl = NewRepl(strings.NewReader("!2\n"), &output)
+	repl.HistoryFile = historyFile
+	if err = repl.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(repl.history) != 3 || repl.history[1] != ": t\n;" {
+		t.Errorf("history: got %q", repl.history)
+	}
+}
+
+func TestSession(t *testing.T) {
+	session := NewSession()
+	if !session.Complete(": t 1 ;") || session.Complete(": t") || session.Complete("1 if") || session.Complete("( a") {
+		t.Errorf("Complete: wrong result")
+	}
+	if _, err := session.Compile(": t 1 ;"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := session.Label("t"); exists {
+		t.Errorf("t: defined before Commit")
+	}
+	session.Commit()
+	if label, exists := session.Label("t"); !exists || label != "T_COL" {
+		t.Errorf("t: expected T_COL, got %q", label)
+	}
+	if _, err := session.Compile(": t"); err == nil {
+		t.Errorf("incomplete unit: error expected")
+	}
+}
@@ -0,0 +1,197 @@
+package forth
+
+import (
+	"io"
+	"strings"
+)
+
+// Incremental compilation
+//
+// A session compiles a program one unit at a time. A unit is a line or a
+// group of lines containing complete definitions and control structures.
+// The definitions, the constants and the labels of the compiled units are
+// kept, so each unit is compiled against the accumulated dictionary.
+//
+// The code of a unit starts at the label start and ends with hlt, followed
+// by the subroutines (colon definitions and library words) and by the data.
+// The labels of the previous units are not defined in the generated code:
+// they must be provided to the assembler (e.g. with Options.Defines).
+// The run time variables (HERE, BASE, ...) are defined by the first unit.
+
+// Labels of the run time variables, defined by the first unit of a session
+var runtimeLabels = []string{herePointer, createPointer, numberBase, holdPointer, holdEnd, compilePointer, compileBuffer, catchHandler, abortMessage, currentTask, mainTask}
+
+type Session struct {
+	status  *CompilerStatus // status after the last committed unit
+	pending *CompilerStatus // status after the last compiled unit
+	units   int             // number of committed units
+}
+
+// Start a session with the standard words
+func NewSession() *Session {
+	return NewCompiler().NewSession()
+}
+
+// Start a session with the words of the compiler
+func (compiler *Compiler) NewSession() *Session {
+	return newSession(compiler.words())
+}
+
+// Start a session with a set of standard words
+func newSession(standard *wordSet) *Session {
+	session := new(Session)
+	session.status = newCompilerStatus(standard, First, nil, nil, nil)
+	session.status.session = true
+	return session
+}
+
+// Return a copy of the status, ready to compile a new unit
+func (status *CompilerStatus) clone(pass Pass) *CompilerStatus {
+	c := newCompilerStatus(status.standard, pass, nil, cloneMap(status.labels), cloneMap(status.constants))
+	c.dictionary = cloneMap(status.dictionary)
+	c.defining = cloneMap(status.defining)
+	c.used = cloneMap(status.used)
+	c.library = cloneMap(status.library)
+	c.xts = cloneMap(status.xts)
+	c.immediate = cloneMap(status.immediate)
+	c.values = cloneMap(status.values)
+	c.deferred = cloneMap(status.deferred)
+	c.hereId = status.hereId
+	c.stringId = status.stringId
+	c.base = status.base
+	c.last = status.last
+	c.console = status.console
+	c.session = status.session
+	c.evaluator = status.evaluator
+	c.prelude.WriteString(status.prelude.String())
+	return c
+}
+
+// Compile a unit, return the status after the compilation
+func (session *Session) compileUnit(text string, pass Pass, labels map[string]bool, output *strings.Builder) (*CompilerStatus, error) {
+	status := session.status.clone(pass)
+	defer status.closeEvaluator()
+	status.output = output
+	if labels != nil {
+		status.labels = labels
+	}
+	if status.pass == Second {
+		output.WriteString("start:\n")
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if err := CompileLine(status, line); err != nil {
+			return status, err
+		}
+		if status.pass == Second {
+			output.WriteString("\n")
+		}
+	}
+	if !status.context.Is(None) || status.interpreting {
+		return status, nil // incomplete unit
+	}
+	status.flushLiterals()
+	if status.pass == Second {
+		output.WriteString("  hlt\n")
+	}
+	if err := status.compileLibrary(); err != nil {
+		return status, err
+	}
+	if status.pass == Second {
+		output.WriteString(status.buf.String())
+		if session.units == 0 {
+			status.writeData()
+		} else {
+			status.writeUnitData()
+		}
+	}
+	return status, nil
+}
+
+// Check if the text is a complete unit, false if a definition, a control structure or a comment is not terminated
+func (session *Session) Complete(text string) bool {
+	var output strings.Builder
+	status, err := session.compileUnit(text, First, nil, &output)
+	if err != nil {
+		return true // the error is reported by Compile
+	}
+	return status.context.Is(None) && !status.interpreting
+}
+
+// Compile a unit and return the generated assembly, the unit is added to the session by Commit
+func (session *Session) Compile(text string) (string, error) {
+	var output strings.Builder
+	status, err := session.compileUnit(text, First, nil, &output)
+	if err != nil {
+		return "", err
+	}
+	if !status.context.Is(None) || status.interpreting {
+		return "", NewCompilerError("incomplete definition or control structure")
+	}
+	output.Reset()
+	if status, err = session.compileUnit(text, Second, status.labels, &output); err != nil {
+		return "", err
+	}
+	session.pending = status
+	return output.String(), nil
+}
+
+// Add the last compiled unit to the session
+func (session *Session) Commit() {
+	if session.pending != nil {
+		session.status = session.pending
+		session.pending = nil
+		session.units++
+	}
+}
+
+// Return the labels defined by the committed units, to be used by the following units
+func (session *Session) Labels() []string {
+	labels := []string{}
+	for label := range session.status.labels {
+		labels = append(labels, label)
+	}
+	for _, label := range runtimeLabels {
+		labels = append(labels, strings.ToUpper(label))
+	}
+	return labels
+}
+
+// Return the names of the words defined in the session
+func (session *Session) Words() []string {
+	words := []string{}
+	for name := range session.status.dictionary {
+		if _, standard := session.status.standard.definitions[name]; !standard {
+			words = append(words, strings.ToLower(name))
+		}
+	}
+	for name := range session.status.constants {
+		if _, standard := session.status.standard.constants[name]; !standard {
+			words = append(words, strings.ToLower(name))
+		}
+	}
+	return words
+}
+
+// Return the label of a word defined in the session (variables, data, values, deferred words and colon definitions)
+func (session *Session) Label(name string) (string, bool) {
+	fields := strings.Fields(session.status.dictionary[strings.ToUpper(name)])
+	if len(fields) == 1 || (len(fields) == 2 && (fields[1] == "call" || fields[1] == "@" || fields[1] == "(defer)")) {
+		if label := strings.ToUpper(fields[0]); session.status.labels[label] {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// Set the output of the words executed at compile time
+func (session *Session) SetConsole(console io.Writer) {
+	session.status.console = console
+}
+
+// Write the data segment of a unit following the first one
+func (status *CompilerStatus) writeUnitData() {
+	status.align()
+	status.output.WriteString("\n.data\n")
+	status.output.WriteString(status.data.String())
+	status.output.WriteString(dataEnd + ":\n.text\n")
+}
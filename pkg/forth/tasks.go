@@ -0,0 +1,55 @@
+package forth
+
+import (
+	"fmt"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+)
+
+// Cooperative multitasking
+//
+// TASK name reserves the control block of a task, followed by its data and
+// return stacks. xt name ACTIVATE starts the task executing xt; the task is
+// inserted into a round-robin list after the current task, and it stops when
+// xt returns (or when it executes STOP). A running task is removed from the
+// list and restarted.
+//
+// PAUSE saves the data stack pointer and base, the return stack pointer and
+// base and the innermost CATCH frame of the current task in its control
+// block, then it restores the registers of the next task in the list and
+// returns to it. The program counter is the return address of PAUSE, on the
+// return stack of the task. The floating-point stack is shared by the tasks.
+//
+// A mailbox holds one message (a cell and a full flag): SEND waits until the
+// mailbox is empty, RECEIVE waits until it is full. A semaphore is a counter:
+// ACQUIRE waits until it is greater than zero and decrements it, RELEASE
+// increments it. The words waiting for the other tasks call PAUSE.
+
+// Labels of the cell containing the address of the control block of the current task and of the control block of the main program
+const currentTask = "task_current"
+const mainTask = "task_main"
+
+// Offsets of the fields of a task control block
+const (
+	taskLink        = iota * int(fcpu.WordSize) // next task in the round-robin list
+	taskSp                                      // data stack pointer
+	taskSb                                      // data stack base
+	taskRsp                                     // return stack pointer
+	taskRbp                                     // return stack base
+	taskHandler                                 // innermost CATCH frame
+	taskControlSize                             // size of the control block
+)
+
+// Size of the data stack and of the return stack of a task
+const taskStackSize = 256 * int(fcpu.WordSize)
+
+// Offsets of the base of the data stack and of the base of the return stack from the control block
+const taskDataBase = taskControlSize + taskStackSize
+const taskReturnBase = taskDataBase + taskStackSize
+
+// Write the round-robin list of the tasks, containing the main program, used by PAUSE
+func (status *CompilerStatus) writeTaskData() {
+	if status.session || status.library["PAUSE"] {
+		status.output.WriteString(fmt.Sprintf("%s: .word %s\n", currentTask, mainTask))
+		status.output.WriteString(fmt.Sprintf("%s: .word %s 0 0 0 0 0\n", mainTask, mainTask))
+	}
+}
@@ -0,0 +1,78 @@
+package forth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tokenizer splits a line into space delimited words. The parsing words
+// (." S" C" .( ABORT" and the comments) read the text up to a delimiter.
+type Tokenizer struct {
+	line string
+	pos  int // position of the next char in the line
+}
+
+func NewTokenizer(line string) *Tokenizer {
+	return &Tokenizer{line: line}
+}
+
+// Check if a char is a word delimiter
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f' || ch == '\v'
+}
+
+// Return the next word, false at the end of the line
+func (t *Tokenizer) Word() (string, bool) {
+	for t.pos < len(t.line) && isSpace(t.line[t.pos]) {
+		t.pos++
+	}
+	if t.pos >= len(t.line) {
+		return "", false
+	}
+	start := t.pos
+	for t.pos < len(t.line) && !isSpace(t.line[t.pos]) {
+		t.pos++
+	}
+	return t.line[start:t.pos], true
+}
+
+// Return the text up to the delimiter, skipping the space following the parsing word.
+// The delimiter is consumed, found is false if the line ends before the delimiter.
+func (t *Tokenizer) Parse(delimiter byte) (text string, found bool) {
+	if t.pos < len(t.line) && isSpace(t.line[t.pos]) {
+		t.pos++
+	}
+	start := t.pos
+	end := strings.IndexByte(t.line[start:], delimiter)
+	if end == -1 {
+		t.pos = len(t.line)
+		return t.line[start:], false
+	}
+	t.pos = start + end + 1
+	return t.line[start : start+end], true
+}
+
+// Skip the rest of the line
+func (t *Tokenizer) SkipLine() {
+	t.pos = len(t.line)
+}
+
+// Return a string literal for the assembler, escaping quotes, backslashes and non-printable chars
+func asmString(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"' || ch == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(ch)
+		case ch < ' ' || ch > '~':
+			fmt.Fprintf(&buf, "\\x%02x", ch)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,117 @@
+package linker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const ArchiveMagic uint32 = 0xc9f7a7c1
+
+// Archive library: a collection of relocatable objects
+//
+// The archive file contains the magic number, the number of members and,
+// for each member, the name length (uint16), the name, the object size (uint32)
+// and the object
+type Archive struct {
+	Name    string
+	Members []*asm.Object
+}
+
+// Check if a file is an archive
+func IsArchive(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var magic uint32
+	if err = binary.Read(file, binary.LittleEndian, &magic); err != nil {
+		return false
+	}
+	return magic == ArchiveMagic
+}
+
+// Read an archive file
+func ReadArchiveFile(filename string) (*Archive, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var magic, count uint32
+	if err = binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, err
+	}
+	if magic != ArchiveMagic {
+		return nil, new(fcpu.ExecFormatError)
+	}
+	if err = binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	archive := &Archive{Name: filename}
+	for i := uint32(0); i < count; i++ {
+		var length uint16
+		var size uint32
+		if err = binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		name := make([]byte, length)
+		if _, err = io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		if err = binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		obj, err := asm.ReadObject(bytes.NewReader(data), filename+"("+string(name)+")")
+		if err != nil {
+			return nil, err
+		}
+		archive.Members = append(archive.Members, obj)
+	}
+	return archive, nil
+}
+
+// Create an archive file from relocatable object files
+func WriteArchiveFile(filename string, objectFilenames []string) error {
+	output, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	w := bufio.NewWriter(output)
+	if err = binary.Write(w, binary.LittleEndian, ArchiveMagic); err != nil {
+		return err
+	}
+	if err = binary.Write(w, binary.LittleEndian, uint32(len(objectFilenames))); err != nil {
+		return err
+	}
+	for _, objectFilename := range objectFilenames {
+		data, err := os.ReadFile(objectFilename)
+		if err != nil {
+			return err
+		}
+		// Check the object
+		if _, err = asm.ReadObject(bytes.NewReader(data), objectFilename); err != nil {
+			return err
+		}
+		name := filepath.Base(objectFilename)
+		fields := []any{uint16(len(name)), []byte(name), uint32(len(data)), data}
+		for _, field := range fields {
+			if err = binary.Write(w, binary.LittleEndian, field); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
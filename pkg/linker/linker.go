@@ -0,0 +1,284 @@
+package linker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"os"
+	"sort"
+	"strings"
+)
+
+type DuplicateSymbol struct {
+	Name    string
+	Objects []string
+}
+
+func (e *DuplicateSymbol) Error() string {
+	return fmt.Sprintf("Duplicate symbol %s in %s", e.Name, strings.Join(e.Objects, ", "))
+}
+
+type UndefinedReference struct {
+	Name   string
+	Object string
+}
+
+func (e *UndefinedReference) Error() string {
+	return fmt.Sprintf("Undefined reference to %s in %s", e.Name, e.Object)
+}
+
+// List of errors collected during the link
+type ErrorList []error
+
+func (list ErrorList) Error() string {
+	messages := make([]string, len(list))
+	for i, err := range list {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Object placed in the executable
+type section struct {
+	obj  *asm.Object
+	text fcpu.Addr // address of the object text
+	data fcpu.Addr // address of the object data
+}
+
+// Linker status
+type Linker struct {
+	sections []*section
+	archives []*Archive
+	globals  map[string]fcpu.Addr // global symbol addresses
+	errors   ErrorList
+}
+
+func NewLinker() (linker *Linker) {
+	linker = new(Linker)
+	linker.globals = map[string]fcpu.Addr{}
+	return linker
+}
+
+// Add an object or an archive file
+func (linker *Linker) AddFile(filename string) error {
+	if IsArchive(filename) {
+		archive, err := ReadArchiveFile(filename)
+		if err != nil {
+			return err
+		}
+		linker.archives = append(linker.archives, archive)
+		return nil
+	}
+	obj, err := asm.ReadObjectFile(filename)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	linker.AddObject(obj)
+	return nil
+}
+
+// Add an object
+func (linker *Linker) AddObject(obj *asm.Object) {
+	linker.sections = append(linker.sections, &section{obj: obj})
+}
+
+// Check if a symbol is defined as global by an object
+func defines(obj *asm.Object, name string) bool {
+	for _, symbol := range obj.Symbols {
+		if symbol.Name == name && symbol.IsGlobal() {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the extern symbols not defined by any object
+func (linker *Linker) undefined() []string {
+	defined := map[string]bool{}
+	for _, s := range linker.sections {
+		for _, symbol := range s.obj.Symbols {
+			if symbol.IsGlobal() {
+				defined[symbol.Name] = true
+			}
+		}
+	}
+	undefined := map[string]bool{}
+	for _, s := range linker.sections {
+		for _, symbol := range s.obj.Symbols {
+			if symbol.IsExtern() && !defined[symbol.Name] {
+				undefined[symbol.Name] = true
+			}
+		}
+	}
+	names := []string{}
+	for name := range undefined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Find the first archive member (not yet loaded) defining a symbol
+func (linker *Linker) findMember(name string, loaded map[*asm.Object]bool) *asm.Object {
+	for _, archive := range linker.archives {
+		for _, obj := range archive.Members {
+			if !loaded[obj] && defines(obj, name) {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// Add the archive members defining undefined symbols, until no more symbols can be resolved
+func (linker *Linker) resolveArchives() {
+	loaded := map[*asm.Object]bool{}
+	for {
+		added := false
+		for _, name := range linker.undefined() {
+			if obj := linker.findMember(name, loaded); obj != nil {
+				loaded[obj] = true
+				linker.AddObject(obj)
+				added = true
+			}
+		}
+		if !added {
+			return
+		}
+	}
+}
+
+// Round up to the word size
+func align(addr fcpu.Addr) fcpu.Addr {
+	return (addr + fcpu.WordSize - 1) &^ (fcpu.WordSize - 1)
+}
+
+// Return the final address of a symbol defined in a section
+func (s *section) relocate(symbol *asm.Symbol) fcpu.Addr {
+	if s.obj.IsData(symbol) {
+		return symbol.Value - s.obj.Header.DataBase + s.data
+	}
+	return symbol.Value - s.obj.Header.TextBase + s.text
+}
+
+// Link the objects, return the text and data segments
+func (linker *Linker) Link() (text []byte, data []byte, err error) {
+	linker.resolveArchives()
+	// Place the sections
+	textAddr := asm.TextSegment
+	dataAddr := asm.DataSegment
+	for _, s := range linker.sections {
+		s.text = textAddr
+		s.data = dataAddr
+		textAddr = align(textAddr + s.obj.Header.TextSize)
+		dataAddr = align(dataAddr + s.obj.Header.DataSize)
+	}
+	// Collect the global symbols
+	definitions := map[string][]string{}
+	for _, s := range linker.sections {
+		for i := range s.obj.Symbols {
+			symbol := &s.obj.Symbols[i]
+			if symbol.IsGlobal() {
+				definitions[symbol.Name] = append(definitions[symbol.Name], s.obj.Name)
+				linker.globals[symbol.Name] = s.relocate(symbol)
+			}
+		}
+	}
+	names := []string{}
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(definitions[name]) > 1 {
+			linker.errors = append(linker.errors, &DuplicateSymbol{Name: name, Objects: definitions[name]})
+		}
+	}
+	// Concatenate the segments and apply the relocations
+	for _, s := range linker.sections {
+		objText := append([]byte{}, s.obj.Text...)
+		objData := append([]byte{}, s.obj.Data...)
+		reported := map[string]bool{}
+		for _, r := range s.obj.Relocations {
+			symbol := &s.obj.Symbols[r.Symbol]
+			var value fcpu.Addr
+			if symbol.IsExtern() {
+				var exists bool
+				if value, exists = linker.globals[symbol.Name]; !exists {
+					if !reported[symbol.Name] {
+						linker.errors = append(linker.errors, &UndefinedReference{Name: symbol.Name, Object: s.obj.Name})
+						reported[symbol.Name] = true
+					}
+					continue
+				}
+			} else {
+				value = s.relocate(symbol)
+			}
+			segment := objText
+			if r.Segment == asm.SegmentData {
+				segment = objData
+			}
+			if r.Offset+fcpu.WordSize > fcpu.Addr(len(segment)) {
+				return nil, nil, fmt.Errorf("%s: invalid relocation offset %x", s.obj.Name, r.Offset)
+			}
+			binary.LittleEndian.PutUint32(segment[r.Offset:], uint32(value))
+		}
+		text = append(text, objText...)
+		for fcpu.Addr(len(text))%fcpu.WordSize != 0 {
+			text = append(text, byte(fcpu.NOP))
+		}
+		data = append(data, objData...)
+		for fcpu.Addr(len(data))%fcpu.WordSize != 0 {
+			data = append(data, 0)
+		}
+	}
+	if len(linker.errors) != 0 {
+		return nil, nil, linker.errors
+	}
+	return text, data, nil
+}
+
+// Link the objects and write the executable
+func (linker *Linker) WriteExecutable(outputFilename string) error {
+	text, data, err := linker.Link()
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(outputFilename)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	w := bufio.NewWriter(output)
+	// Prepare header
+	var header fcpu.BinaryHeader
+	header.Magic = fcpu.BinaryMagic
+	header.TextSize = fcpu.Addr(len(text))
+	header.DataSize = fcpu.Addr(len(data))
+	header.TextBase = asm.TextSegment
+	header.DataBase = asm.DataSegment
+	// Write header, text and data
+	if err = binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if _, err = w.Write(text); err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Link object and archive files into an executable
+func Link(filenames []string, outputFilename string) error {
+	linker := NewLinker()
+	for _, filename := range filenames {
+		if err := linker.AddFile(filename); err != nil {
+			return err
+		}
+	}
+	return linker.WriteExecutable(outputFilename)
+}
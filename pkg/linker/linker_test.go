@@ -0,0 +1,154 @@
+package linker
+
+import (
+	"errors"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var Halt = new(fcpu.Halt)
+
+const mainSource = `
+.extern double value
+start:
+    push value fetch push double call
+    push counter fetch
+    hlt
+.data
+counter: .word 7
+    .byte 1
+`
+
+const libSource = `
+.global double value
+double:
+    dup add ret
+.data
+value: .word 21
+`
+
+// Assemble a source into a relocatable object
+func assemble(t *testing.T, tmpDir string, name string, source string) string {
+	asmFilename := filepath.Join(tmpDir, name+".pal")
+	objFilename := filepath.Join(tmpDir, name+".o")
+	if err := os.WriteFile(asmFilename, []byte(source), 0666); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := asm.CompileWithOptions(asmFilename, objFilename, asm.Options{Object: true}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return objFilename
+}
+
+// Execute a program, return the CPU
+func run(t *testing.T, objFilename string) *fcpu.CPU {
+	cpu, err := fcpu.NewCPU(objFilename)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	cpu.Limit = 1000
+	for {
+		if err := cpu.Eval(); err != nil {
+			if !errors.Is(err, Halt) {
+				t.Fatalf("%s", err)
+			}
+			return cpu
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	tmpDir := t.TempDir()
+	mainObj := assemble(t, tmpDir, "main", mainSource)
+	libObj := assemble(t, tmpDir, "lib", libSource)
+	exeFilename := filepath.Join(tmpDir, "main.obj")
+	if err := Link([]string{mainObj, libObj}, exeFilename); err != nil {
+		t.Fatalf("%s", err)
+	}
+	cpu := run(t, exeFilename)
+	if !reflect.DeepEqual(cpu.Ds.Array(), []fcpu.Word{42, 7}) {
+		t.Fatalf("Wrong stack content: %d", cpu.Ds.Array())
+	}
+}
+
+func TestLinkArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	mainObj := assemble(t, tmpDir, "main", mainSource)
+	libObj := assemble(t, tmpDir, "lib", libSource)
+	unusedObj := assemble(t, tmpDir, "unused", ".global unused\nunused: ret\n")
+	archiveFilename := filepath.Join(tmpDir, "lib.a")
+	if err := WriteArchiveFile(archiveFilename, []string{unusedObj, libObj}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	exeFilename := filepath.Join(tmpDir, "main.obj")
+	if err := Link([]string{mainObj, archiveFilename}, exeFilename); err != nil {
+		t.Fatalf("%s", err)
+	}
+	cpu := run(t, exeFilename)
+	if !reflect.DeepEqual(cpu.Ds.Array(), []fcpu.Word{42, 7}) {
+		t.Fatalf("Wrong stack content: %d", cpu.Ds.Array())
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	mainObj := assemble(t, tmpDir, "main", mainSource)
+	libObj := assemble(t, tmpDir, "lib", libSource)
+	exeFilename := filepath.Join(tmpDir, "main.obj")
+	// Undefined symbols
+	err := Link([]string{mainObj}, exeFilename)
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("undefined references expected, got: %v", err)
+	}
+	var undefined *UndefinedReference
+	if !errors.As(errs[0], &undefined) || undefined.Name != "VALUE" {
+		t.Fatalf("undefined reference to VALUE expected, got: %v", errs[0])
+	}
+	// Duplicate symbols
+	err = Link([]string{mainObj, libObj, libObj}, exeFilename)
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("duplicate symbols expected, got: %v", err)
+	}
+	var duplicate *DuplicateSymbol
+	if !errors.As(errs[1], &duplicate) || duplicate.Name != "VALUE" {
+		t.Fatalf("duplicate symbol VALUE expected, got: %v", errs[1])
+	}
+}
+
+func TestUndefinedGlobal(t *testing.T) {
+	tmpDir := t.TempDir()
+	asmFilename := filepath.Join(tmpDir, "lib.pal")
+	if err := os.WriteFile(asmFilename, []byte(".global double nosuch\ndouble: dup add ret\n"), 0666); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := asm.CompileWithOptions(asmFilename, filepath.Join(tmpDir, "lib.o"), asm.Options{Object: true})
+	var errs asm.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("undefined symbol expected, got: %v", err)
+	}
+	var undefined *asm.UndefinedSymbol
+	if !errors.As(errs[0], &undefined) || undefined.Label != "NOSUCH" || undefined.Line != 1 || undefined.Column != 16 {
+		t.Fatalf("undefined symbol NOSUCH at 1:16 expected, got: %v %s", errs[0], errs[0].Position())
+	}
+}
+
+func TestGlobalLine(t *testing.T) {
+	// The symbols of .global and .extern are on the line of the directive
+	obj, err := asm.AssembleObject("lib.pal", ".data\n.global table .extern other\nentry\ntable: .word entry\nentry: .word 42\n", asm.Options{Object: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(obj.Data) != 12 || len(obj.Relocations) != 2 {
+		t.Fatalf("3 words and 2 relocations expected, got %d bytes and %d relocations", len(obj.Data), len(obj.Relocations))
+	}
+	for _, symbol := range obj.Symbols {
+		if symbol.IsGlobal() != (symbol.Name == "TABLE") {
+			t.Errorf("%s: global %v", symbol.Name, symbol.IsGlobal())
+		}
+	}
+}
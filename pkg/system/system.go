@@ -0,0 +1,38 @@
+// Package system builds the self-hosted Forth system image.
+//
+// The system is written in Forth (with inline assembly) and it is compiled by
+// the Forth compiler and by the assembler. The resulting binary boots on the
+// CPU, reads the source from the input (KEY) and compiles the colon
+// definitions to native code at run time.
+package system
+
+import (
+	_ "embed"
+	asm "github.com/andreax79/go-fcpu/pkg/assembler"
+	forth "github.com/andreax79/go-fcpu/pkg/forth"
+	"os"
+	"path/filepath"
+)
+
+// Source of the Forth system
+//
+//go:embed forth.ft
+var Source string
+
+// Build the system image, writing the binary to outputFilename
+func Build(outputFilename string) error {
+	tmpDir, err := os.MkdirTemp("", "system")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	forthFilename := filepath.Join(tmpDir, "forth.ft")
+	asmFilename := forthFilename + ".pal"
+	if err = os.WriteFile(forthFilename, []byte(Source), 0666); err != nil {
+		return err
+	}
+	if err = forth.Compile(forthFilename, asmFilename); err != nil {
+		return err
+	}
+	return asm.Compile(asmFilename, outputFilename, false)
+}
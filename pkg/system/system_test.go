@@ -0,0 +1,105 @@
+package system
+
+import (
+	"bytes"
+	fcpu "github.com/andreax79/go-fcpu/pkg/fcpu"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Boot the system, interpret the input and return the output
+func runSystem(t *testing.T, image string, input string) string {
+	cpu, err := fcpu.NewCPU(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	cpu.Output = &output
+	cpu.Input = strings.NewReader(input)
+	cpu.Limit = 100000000
+	for {
+		err = cpu.Eval()
+		if _, halt := err.(*fcpu.Halt); halt {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpu.Time >= cpu.Limit {
+		t.Fatalf("%q: instructions limit exceeded", input)
+	}
+	return output.String()
+}
+
+func TestSystem(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	image := filepath.Join(tmpDir, "system.obj")
+	if err = Build(image); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 2 + .\n", "3  ok\n"},
+		{"1 2 3 .s", "<3> 1 2 3  ok\n"},
+		{": sq dup * ;\n7 sq .\n", " ok\n49  ok\n"},
+		{": fact dup 1 > if dup 1- recurse * else drop 1 then ; 10 fact .", "3628800  ok\n"},
+		{": t 5 0 do i . loop ; t", "0 1 2 3 4  ok\n"},
+		{": t 10 0 do i 3 +loop ; t .s", "<4> 0 3 6 9  ok\n"},
+		{": t 0 ?do 42 emit loop ; 3 t 0 t", "*** ok\n"},
+		{": t 3 1 do 3 1 do i j * . loop loop ; t", "1 2 2 4  ok\n"},
+		{": t begin dup . 1- dup 0= until drop ; 3 t", "3 2 1  ok\n"},
+		{": t begin dup while dup . 1- repeat drop ; 3 t", "3 2 1  ok\n"},
+		{`: t ." Hello, world!" ; t`, "Hello, world! ok\n"},
+		{`: t s" abc" type ; t s" de" type`, "abcde ok\n"},
+		{"hex ff . decimal $10 . #20 . %101 . -7 .", "FF 16 20 5 -7  ok\n"},
+		{"variable v 42 v ! v @ . 7 constant seven seven .", "42 7  ok\n"},
+		{"create a 1 , 2 , 3 , a cell+ @ .", "2  ok\n"},
+		{": sq dup * ; 6 ' sq execute .", "36  ok\n"},
+		{"char A . : t [char] B emit ; t", "65 B ok\n"},
+		{": t [ 2 3 * ] literal ; t .", "6  ok\n"},
+		{": u postpone if postpone then ; immediate : t 1 u 2 ; t .", "2  ok\n"},
+		{"( comment ) 1 . \\ comment\n.( text)", "1  ok\ntext ok\n"},
+		{"1 2 foo 3 .s", "foo ?\n"},
+		{": t 1 foo ;\nt", "foo ?\nt ?\n"},
+		{"drop", "stack underflow\n"},
+		{"key .", "-1  ok\n"},
+	}
+	for _, test := range tests {
+		if result := runSystem(t, image, test.input); result != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expected, result)
+		}
+	}
+}
+
+func TestWordsForget(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	image := filepath.Join(tmpDir, "system.obj")
+	if err = Build(image); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runSystem(t, image, ": a ; : b ; : c ; words")
+	if !strings.HasPrefix(result, "c b a bye forget words ") || !strings.HasSuffix(result, " dup \n ok\n") {
+		t.Errorf("words: got %q", result)
+	}
+	result = runSystem(t, image, ": a ; : b ; : c ;\nforget b words\nb\n")
+	if !strings.HasPrefix(result, " ok\na bye ") || !strings.HasSuffix(result, " ok\nb ?\n") {
+		t.Errorf("forget: got %q", result)
+	}
+	if result = runSystem(t, image, "forget dup"); result != "protected\n" {
+		t.Errorf("forget: got %q", result)
+	}
+}